@@ -0,0 +1,212 @@
+package accesslog
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/textproto"
+	"time"
+)
+
+// Format is the output format of the access log.
+type Format int
+
+const (
+	// FormatJSON writes one json object per line.
+	FormatJSON Format = iota
+	// FormatLogfmt writes key=value pairs per line.
+	FormatLogfmt
+	// FormatCombined writes the nginx combined log format.
+	FormatCombined
+)
+
+// keys of the access log fields.
+const (
+	KeyMethod     = "method"
+	KeyURL        = "url"
+	KeyProto      = "proto"
+	KeyNode       = "node"
+	KeyAttempts   = "attempts"
+	KeyStatus     = "status"
+	KeyBytesIn    = "bytes_in"
+	KeyBytesOut   = "bytes_out"
+	KeyLatency    = "latency"
+	KeyErrorClass = "error_class"
+	KeyError      = "error"
+	KeyRemoteAddr = "remote_addr"
+	KeyUserAgent  = "user_agent"
+	KeyReferer    = "referer"
+	KeyHeader     = "header"
+)
+
+const redacted = "[REDACTED]"
+
+// defaultRedactHeaders are always redacted.
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Entry is a single proxied request.
+type Entry struct {
+	Request *http.Request
+	// Node is the address of the selected node, empty if none selected.
+	Node string
+	// Attempts is the number of attempts sent to upstream.
+	Attempts int
+	// Status is the response status code, zero if no response received.
+	Status int
+	// BytesIn is the bytes sent to upstream, -1 if unknown.
+	BytesIn int64
+	// BytesOut is the response body bytes received from upstream, -1 if no response received.
+	BytesOut int64
+	// Latency is the time until the response body is read to EOF or closed, or the request failed.
+	Latency time.Duration
+	// ErrorClass is the class of the failure, empty on success.
+	ErrorClass string
+	Err        error
+}
+
+// Option is access logger option.
+type Option func(o *options)
+
+// options is access logger options
+type options struct {
+	format     Format
+	handler    slog.Handler
+	sampleRate float64
+	headers    []string
+	redact     map[string]struct{}
+}
+
+// WithFormat set the output format, default is FormatJSON.
+func WithFormat(f Format) Option {
+	return func(o *options) {
+		o.format = f
+	}
+}
+
+// WithHandler set a custom slog.Handler as the sink, the format and writer are ignored.
+func WithHandler(h slog.Handler) Option {
+	return func(o *options) {
+		o.handler = h
+	}
+}
+
+// WithSampleRate set the ratio [0, 1] of successful requests to log, default is 1.
+// failed requests are always logged.
+func WithSampleRate(rate float64) Option {
+	return func(o *options) {
+		o.sampleRate = rate
+	}
+}
+
+// WithHeaders set the request headers to log.
+func WithHeaders(names ...string) Option {
+	return func(o *options) {
+		o.headers = append(o.headers, names...)
+	}
+}
+
+// WithRedactHeaders set the headers whose values are redacted, in addition to
+// Authorization, Proxy-Authorization, Cookie and Set-Cookie.
+func WithRedactHeaders(names ...string) Option {
+	return func(o *options) {
+		for _, name := range names {
+			o.redact[textproto.CanonicalMIMEHeaderKey(name)] = struct{}{}
+		}
+	}
+}
+
+// Logger writes access logs through log/slog.
+//
+// All methods are safe to call on a nil *Logger, which logs nothing.
+type Logger struct {
+	logger *slog.Logger
+	opts   options
+}
+
+// New create an access logger writing to w.
+func New(w io.Writer, opts ...Option) *Logger {
+	o := options{
+		sampleRate: 1,
+		redact:     make(map[string]struct{}, len(defaultRedactHeaders)),
+	}
+	for _, name := range defaultRedactHeaders {
+		o.redact[name] = struct{}{}
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	h := o.handler
+	if h == nil {
+		switch o.format {
+		case FormatLogfmt:
+			h = slog.NewTextHandler(w, nil)
+		case FormatCombined:
+			h = NewCombinedHandler(w)
+		default:
+			h = slog.NewJSONHandler(w, nil)
+		}
+	}
+	return &Logger{logger: slog.New(h), opts: o}
+}
+
+// Log writes e if it is sampled.
+func (l *Logger) Log(ctx context.Context, e *Entry) {
+	if l == nil || !l.sampled(e) {
+		return
+	}
+	l.logger.LogAttrs(ctx, slog.LevelInfo, "access", l.attrs(e)...)
+}
+
+func (l *Logger) sampled(e *Entry) bool {
+	if e.ErrorClass != "" || e.Err != nil || l.opts.sampleRate >= 1 {
+		return true
+	}
+	return rand.Float64() < l.opts.sampleRate
+}
+
+func (l *Logger) attrs(e *Entry) []slog.Attr {
+	req := e.Request
+	attrs := []slog.Attr{
+		slog.String(KeyMethod, req.Method),
+		slog.String(KeyURL, req.URL.Redacted()),
+		slog.String(KeyProto, req.Proto),
+		slog.String(KeyNode, e.Node),
+		slog.Int(KeyAttempts, e.Attempts),
+		slog.Int(KeyStatus, e.Status),
+		slog.Int64(KeyBytesIn, e.BytesIn),
+		slog.Int64(KeyBytesOut, e.BytesOut),
+		slog.Duration(KeyLatency, e.Latency),
+		slog.String(KeyRemoteAddr, req.RemoteAddr),
+		slog.String(KeyUserAgent, l.header(req.Header, "User-Agent")),
+		slog.String(KeyReferer, l.header(req.Header, "Referer")),
+	}
+	if e.ErrorClass != "" {
+		attrs = append(attrs, slog.String(KeyErrorClass, e.ErrorClass))
+	}
+	if e.Err != nil {
+		attrs = append(attrs, slog.String(KeyError, e.Err.Error()))
+	}
+	if len(l.opts.headers) > 0 {
+		headers := make([]any, 0, len(l.opts.headers))
+		for _, name := range l.opts.headers {
+			headers = append(headers, slog.String(name, l.header(req.Header, name)))
+		}
+		attrs = append(attrs, slog.Group(KeyHeader, headers...))
+	}
+	return attrs
+}
+
+// header returns the value of header name, redacted if it's sensitive.
+func (l *Logger) header(h http.Header, name string) string {
+	v := h.Get(name)
+	if v == "" {
+		return ""
+	}
+	if _, ok := l.opts.redact[textproto.CanonicalMIMEHeaderKey(name)]; ok {
+		return redacted
+	}
+	return v
+}
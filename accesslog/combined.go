@@ -0,0 +1,88 @@
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// combinedTimeFormat is the nginx $time_local format.
+const combinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+var _ slog.Handler = (*CombinedHandler)(nil)
+
+// CombinedHandler is a slog.Handler writing access records in the nginx combined log format:
+//
+//	$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"
+type CombinedHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	attrs []slog.Attr
+}
+
+// NewCombinedHandler create a combined log handler writing to w.
+func NewCombinedHandler(w io.Writer) *CombinedHandler {
+	return &CombinedHandler{mu: &sync.Mutex{}, w: w}
+}
+
+// Enabled reports all levels are enabled.
+func (h *CombinedHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Handle writes a single line of r.
+func (h *CombinedHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := make(map[string]slog.Value, r.NumAttrs()+len(h.attrs))
+	for _, a := range h.attrs {
+		fields[a.Key] = a.Value
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		fields[a.Key] = a.Value
+		return true
+	})
+
+	str := func(key string) string {
+		if v, ok := fields[key]; ok && v.String() != "" {
+			return v.String()
+		}
+		return "-"
+	}
+	remote := str(KeyRemoteAddr)
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	status := str(KeyStatus)
+	bytesOut := "-"
+	if v, ok := fields[KeyBytesOut]; ok && v.Kind() == slog.KindInt64 && v.Int64() >= 0 {
+		bytesOut = strconv.FormatInt(v.Int64(), 10)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintf(h.w, "%s - - [%s] \"%s %s %s\" %s %s %q %q\n",
+		remote,
+		r.Time.Format(combinedTimeFormat),
+		str(KeyMethod), str(KeyURL), str(KeyProto),
+		status,
+		bytesOut,
+		str(KeyReferer),
+		str(KeyUserAgent),
+	)
+	return err
+}
+
+// WithAttrs returns a handler which always writes attrs.
+func (h *CombinedHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	n := &CombinedHandler{mu: h.mu, w: h.w}
+	n.attrs = append(append(n.attrs, h.attrs...), attrs...)
+	return n
+}
+
+// WithGroup returns h, the combined format has no groups.
+func (h *CombinedHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrBodyNotClosed is reported in selector.DoneInfo.ReadErr when a response body
+// is garbage collected without being closed.
+var ErrBodyNotClosed = errors.New("body_not_closed")
+
+// body completes an upstream request when the response body is read to EOF or closed.
+type body struct {
+	io.ReadCloser
+	// src is the response of the transport, dst is the copy returned with this body.
+	src, dst *http.Response
+	state    *bodyState
+	guard    *bodyGuard
+}
+
+// bodyState is the completion state of a body.
+type bodyState struct {
+	n      int64
+	once   sync.Once
+	finish func(n int64, trailer http.Header, err error)
+}
+
+// bodyGuard is finalized when the body is garbage collected without being closed.
+// It is only reachable from the body, and reaches neither the body nor the response,
+// so that it isn't kept alive by the transport or a cycle through the response.
+type bodyGuard struct {
+	state *bodyState
+}
+
+// newBody returns a copy of resp whose body calls finish once with the bytes read,
+// the trailer and the read error when it is read to EOF or closed, also if it is
+// never closed. finish must not reference the response.
+func newBody(resp *http.Response, finish func(n int64, trailer http.Header, err error)) *http.Response {
+	out := *resp
+	state := &bodyState{finish: finish}
+	guard := &bodyGuard{state: state}
+	runtime.SetFinalizer(guard, func(g *bodyGuard) {
+		g.state.done(nil, ErrBodyNotClosed)
+	})
+	out.Body = &body{ReadCloser: resp.Body, src: resp, dst: &out, state: state, guard: guard}
+	return &out
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.state.n, int64(n))
+	if err == io.EOF {
+		// the transport sets the trailer of its response at EOF
+		b.dst.Trailer = b.src.Trailer
+		b.done(nil)
+	} else if err != nil {
+		b.done(err)
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	err := b.ReadCloser.Close()
+	b.done(nil)
+	return err
+}
+
+func (b *body) done(err error) {
+	runtime.SetFinalizer(b.guard, nil)
+	b.state.done(b.dst.Trailer, err)
+}
+
+func (s *bodyState) done(trailer http.Header, err error) {
+	s.once.Do(func() {
+		s.finish(atomic.LoadInt64(&s.n), trailer, err)
+	})
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+)
+
+func TestBodyDone(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Load")
+		w.Header().Set("X-Server", "a")
+		w.(http.Flusher).Flush()
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte("hello"))
+		w.Header().Set("X-Load", "0.5")
+	}))
+	defer ts.Close()
+
+	dones := make(chan selector.DoneInfo, 1)
+	p := New(
+		WithInitialNodes([]selector.Node{selector.NewNode("http", ts.URL[7:], nil)}),
+		WithObserver(selector.Observer{
+			OnDone: func(_ context.Context, _ selector.Node, di selector.DoneInfo, _ time.Duration) {
+				dones <- di
+			},
+		}),
+	)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	resp, err := p.Do(req)
+	assert.NoError(t, err)
+	assert.Len(t, dones, 0)
+
+	b, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	di := <-dones
+	assert.Equal(t, int64(5), di.ResponseBytes)
+	assert.NoError(t, di.ReadErr)
+	assert.Greater(t, di.Duration, 20*time.Millisecond)
+	assert.Less(t, di.TTFB, di.Duration)
+	assert.Equal(t, http.StatusOK, di.StatusCode)
+	assert.Equal(t, int64(0), di.RequestBytes)
+	assert.Equal(t, 0, di.Attempt)
+	assert.Equal(t, "a", di.ReplyMD.Get("X-Server"))
+	assert.Equal(t, "0.5", di.ReplyMD.Get("X-Load"))
+	assert.False(t, di.Timing.ConnReused)
+	assert.Greater(t, di.Timing.Connect, time.Duration(0))
+
+	// done only once
+	_ = resp.Body.Close()
+	assert.Len(t, dones, 0)
+
+	resp, err = p.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	di = <-dones
+	assert.True(t, di.Timing.ConnReused)
+	assert.Equal(t, time.Duration(0), di.Timing.Connect)
+}
+
+func TestBodyNotClosed(t *testing.T) {
+	done := make(chan error, 1)
+	_ = newBody(&http.Response{Body: io.NopCloser(nil)}, func(_ int64, _ http.Header, err error) {
+		done <- err
+	})
+	runtime.GC()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, ErrBodyNotClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("finish not called")
+	}
+}
+
+func TestReverseProxy_BodyNotClosed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Load")
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(make([]byte, 1<<20))
+		w.Header().Set("X-Load", "0.5")
+	}))
+	defer ts.Close()
+
+	dones := make(chan selector.DoneInfo, 2)
+	p := New(
+		WithInitialNodes([]selector.Node{selector.NewNode("http", ts.URL[7:], nil)}),
+		WithMaxConns(1),
+		WithObserver(selector.Observer{
+			OnDone: func(_ context.Context, _ selector.Node, di selector.DoneInfo, _ time.Duration) {
+				dones <- di
+			},
+		}),
+	)
+
+	func() {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		resp, err := p.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}()
+
+	var di selector.DoneInfo
+	for i := 0; i < 50 && di.ReadErr == nil; i++ {
+		runtime.GC()
+		select {
+		case di = <-dones:
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	assert.Equal(t, ErrBodyNotClosed, di.ReadErr)
+
+	// the in-flight slot is given back
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	resp, err := p.Do(req)
+	if assert.NoError(t, err) {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, "0.5", resp.Trailer.Get("X-Load"))
+	}
+}
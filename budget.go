@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// Budget caps the extra requests, e.g. retried or hedged requests, as a ratio of the
+// original requests plus a minimum per second.
+//
+// Every original request deposits ratio tokens and every extra request withdraws one,
+// the balance never exceeds the tokens of 100 original requests. When the balance
+// is used up, extra requests are still allowed at the minimum rate per second.
+// All methods are safe to call on a nil *Budget, which never withdraws.
+type Budget struct {
+	mu      sync.Mutex
+	ratio   float64
+	max     float64
+	balance float64
+
+	// reserve is a token bucket refilled at minPerSecond.
+	minPerSecond float64
+	reserve      float64
+	refilledAt   time.Time
+}
+
+// defaultBudgetWindow is the number of original requests whose tokens the budget keeps.
+const defaultBudgetWindow = 100
+
+// BudgetOption is budget option.
+type BudgetOption func(b *Budget)
+
+// WithMinPerSecond set the extra requests allowed per second regardless of the ratio.
+func WithMinPerSecond(n int) BudgetOption {
+	return func(b *Budget) {
+		b.minPerSecond = float64(n)
+		b.reserve = float64(n)
+	}
+}
+
+// NewBudget create a budget allowing ratio extra requests per original request, e.g. 0.1 is 10%.
+func NewBudget(ratio float64, opts ...BudgetOption) *Budget {
+	b := &Budget{
+		ratio:      ratio,
+		max:        max(ratio*defaultBudgetWindow, 1),
+		refilledAt: time.Now(),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Deposit records an original request.
+func (b *Budget) Deposit() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.balance += b.ratio
+	if b.balance > b.max {
+		b.balance = b.max
+	}
+}
+
+// Withdraw reports whether an extra request is allowed and takes its token.
+func (b *Budget) Withdraw() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.balance >= 1 {
+		b.balance--
+		return true
+	}
+	if b.minPerSecond <= 0 {
+		return false
+	}
+	now := time.Now()
+	b.reserve = min(b.reserve+now.Sub(b.refilledAt).Seconds()*b.minPerSecond, b.minPerSecond)
+	b.refilledAt = now
+	if b.reserve < 1 {
+		return false
+	}
+	b.reserve--
+	return true
+}
@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+)
+
+// MetadataMaxConns is the node metadata key of the max requests in flight of the node,
+// it overrides the WithMaxConns default.
+const MetadataMaxConns = "max_conns"
+
+var (
+	// ErrQueueTimeout is returned when all nodes are at capacity and the request
+	// waited in the queue for too long.
+	ErrQueueTimeout = errors.New("queue_timeout")
+	// ErrQueueFull is returned when all nodes are at capacity and the queue is full.
+	ErrQueueFull = errors.New("queue_full")
+)
+
+// errSaturated is reported to the balancer when the picked node is at its caps,
+// the request is never sent to it.
+var errSaturated = fmt.Errorf("node_saturated: %w", selector.ErrDiscarded)
+
+// connLimiter caps the requests in flight per node, requests wait in a bounded queue
+// when all nodes are at capacity.
+type connLimiter struct {
+	max      int
+	maxQueue int
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]int
+	waiters  list.List
+}
+
+func newConnLimiter() *connLimiter {
+	return &connLimiter{inflight: make(map[string]int)}
+}
+
+// capOf returns the max requests in flight of n, 0 is unlimited.
+func (c *connLimiter) capOf(n selector.Node) int {
+	if str, ok := n.Metadata()[MetadataMaxConns]; ok {
+		if v, err := strconv.Atoi(str); err == nil {
+			return v
+		}
+	}
+	return c.max
+}
+
+// filter drops the nodes at capacity.
+func (c *connLimiter) filter(_ context.Context, nodes []selector.Node) []selector.Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	filtered := make([]selector.Node, 0, len(nodes))
+	for _, n := range nodes {
+		if limit := c.capOf(n); limit <= 0 || c.inflight[n.Address()] < limit {
+			filtered = append(filtered, n)
+		}
+	}
+	return filtered
+}
+
+// tryAcquire takes a slot of n, reports false if n is at capacity.
+// A forced slot is always taken, e.g. the node is pinned by the peer in context.
+func (c *connLimiter) tryAcquire(n selector.Node, force bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if limit := c.capOf(n); !force && limit > 0 && c.inflight[n.Address()] >= limit {
+		return false
+	}
+	c.inflight[n.Address()]++
+	return true
+}
+
+// release gives back the slot of addr and wakes up a waiter.
+func (c *connLimiter) release(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.inflight[addr]--; c.inflight[addr] <= 0 {
+		delete(c.inflight, addr)
+	}
+	if e := c.waiters.Front(); e != nil {
+		close(c.waiters.Remove(e).(chan struct{}))
+	}
+}
+
+// busy reports whether any request is in flight, a wait without one would never be woken up.
+func (c *connLimiter) busy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.inflight) > 0
+}
+
+// wait blocks until a slot is released, the queue is full or the deadline exceeded.
+func (c *connLimiter) wait(ctx context.Context, deadline time.Time) error {
+	c.mu.Lock()
+	if c.waiters.Len() >= c.maxQueue {
+		c.mu.Unlock()
+		return ErrQueueFull
+	}
+	ready := make(chan struct{})
+	e := c.waiters.PushBack(ready)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = ErrQueueTimeout
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-ready:
+		// woken up concurrently, pass it on
+		if e := c.waiters.Front(); e != nil {
+			close(c.waiters.Remove(e).(chan struct{}))
+		}
+	default:
+		c.waiters.Remove(e)
+	}
+	return err
+}
+
+// depth is the number of waiting requests.
+func (c *connLimiter) depth() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.waiters.Len()
+}
+
+// selectError is a failure selecting a node other than no available node,
+// e.g. waiting for a node under its caps.
+type selectError struct {
+	err error
+}
+
+func (e *selectError) Error() string {
+	return e.err.Error()
+}
+
+func (e *selectError) Unwrap() error {
+	return e.err
+}
+
+// selectNode selects a node predicted to meet the deadline and under its in-flight cap
+// and rate limit, waits in the queue when all nodes are at capacity, or for a token
+// when all nodes are rate limited.
+func (r *ReverseProxy) selectNode(ctx context.Context, filters []selector.NodeFilter) (selector.Node, selector.DoneFunc, error) {
+	deadlines := r.deadlineFilterOf(ctx)
+	if deadlines != nil {
+		filters = append(filters, deadlines.filter)
+	}
+	// the session node is kept last, only if it passes all the other filters
+	sticky := r.stickyFilter(ctx)
+	var rates *nodeFilter
+	if r.rates.limitsNodes() {
+		rates = &nodeFilter{rl: r.rates}
+	}
+	if r.conns == nil && rates == nil {
+		if sticky != nil {
+			filters = append(filters, sticky)
+		}
+		var opts []selector.SelectOption
+		if len(filters) > 0 {
+			opts = append(opts, selector.WithNodeFilter(filters...))
+		}
+		current, done, err := r.selector.Select(ctx, opts...)
+		if errors.Is(err, selector.ErrNoAvailable) && deadlines.unreachable() {
+			return nil, nil, &selectError{err: ErrDeadlineUnreachable}
+		}
+		return current, done, err
+	}
+
+	var (
+		now       = time.Now()
+		deadline  time.Time
+		_, pinned = selector.FromPeerContext(ctx)
+		queued    time.Time
+	)
+	if r.conns != nil {
+		filters = append(filters, r.conns.filter)
+		deadline = now.Add(r.conns.timeout)
+	}
+	if rates != nil {
+		// after the conns filter, only the nodes with a free slot are waited for
+		filters = append(filters, rates.filter)
+	}
+	if sticky != nil {
+		filters = append(filters, sticky)
+	}
+	opts := []selector.SelectOption{selector.WithNodeFilter(filters...)}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, &selectError{err: err}
+		}
+		current, done, err := r.selector.Select(ctx, opts...)
+		if err == nil {
+			// the node may be at its caps if it reached them concurrently, or the selector
+			// ignores the filters, wait instead of selecting it again
+			if r.conns != nil && !r.conns.tryAcquire(current, pinned) {
+				done(ctx, selector.DoneInfo{Err: errSaturated})
+				if r.conns.busy() {
+					if err := r.queue(ctx, deadline, &queued); err != nil {
+						return nil, nil, err
+					}
+				}
+				continue
+			}
+			if rates != nil && !r.rates.take(current, pinned) {
+				r.releaseConn(current.Address())
+				done(ctx, selector.DoneInfo{Err: errSaturated})
+				if err := waitNode(ctx, r.rates.nodeBucket(current).Delay(), now.Add(r.rates.wait)); err != nil {
+					return nil, nil, &selectError{err: err}
+				}
+				continue
+			}
+			if !queued.IsZero() {
+				r.metrics.QueueWait(time.Since(queued))
+			}
+			return current, done, nil
+		}
+		if !errors.Is(err, selector.ErrNoAvailable) {
+			return nil, nil, err
+		}
+		if deadlines.unreachable() {
+			return nil, nil, &selectError{err: ErrDeadlineUnreachable}
+		}
+
+		if rates != nil && rates.delay > 0 {
+			if err := waitNode(ctx, rates.delay, now.Add(r.rates.wait)); err != nil {
+				return nil, nil, &selectError{err: err}
+			}
+			continue
+		}
+		if r.conns == nil || !r.conns.busy() {
+			return nil, nil, err
+		}
+		if err := r.queue(ctx, deadline, &queued); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// queue waits in the queue for a released slot until deadline, queued is set to the
+// time the request is first queued.
+func (r *ReverseProxy) queue(ctx context.Context, deadline time.Time, queued *time.Time) error {
+	if queued.IsZero() {
+		*queued = time.Now()
+	}
+	r.metrics.QueueDepth(r.conns.depth() + 1)
+	err := r.conns.wait(ctx, deadline)
+	r.metrics.QueueDepth(r.conns.depth())
+	if err != nil {
+		r.metrics.QueueWait(time.Since(*queued))
+		return &selectError{err: err}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/limiter"
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/once"
+)
+
+func TestMaxConns(t *testing.T) {
+	var inflight, peak int64
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inflight, 1)
+		defer atomic.AddInt64(&inflight, -1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		<-release
+	}))
+	defer ts.Close()
+
+	p := New(
+		WithInitialNodes([]selector.Node{
+			selector.NewNode("http", ts.URL[7:], selector.RawMetadata(MetadataMaxConns, "2")),
+		}),
+		WithMaxConns(100),
+		WithMaxConnsQueue(1, time.Second),
+	)
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, 4)
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+			resp, err := p.Do(req)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			errs <- err
+		}()
+	}
+
+	// two in flight, one queued and one rejected
+	err := <-errs
+	assert.True(t, errors.Is(err, ErrQueueFull))
+	assert.Equal(t, 1, p.conns.depth())
+	for atomic.LoadInt64(&inflight) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(2), atomic.LoadInt64(&peak))
+}
+
+func TestMaxConnsQueueTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	p := New(
+		WithInitialNodes([]selector.Node{&mockNode{scheme: "http", addr: ts.URL[7:]}}),
+		WithMaxConns(1),
+		WithMaxConnsQueue(1, 20*time.Millisecond),
+	)
+
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		_, _ = p.Do(req)
+	}()
+	for !p.conns.busy() {
+		time.Sleep(time.Millisecond)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	_, err := p.Do(req)
+	assert.True(t, errors.Is(err, ErrQueueTimeout))
+	assert.Equal(t, ClassQueue, ErrorClass(err, 0))
+}
+
+func TestMaxConnsIgnoredFilters(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	// the once selector ignores the node filters and returns the saturated node
+	p := New(
+		WithSelector(once.New()),
+		WithInitialNodes([]selector.Node{
+			selector.NewNode("http", ts.URL[7:], selector.RawMetadata(MetadataRate, "1")),
+		}),
+		WithMaxConns(1),
+		WithMaxConnsQueue(1, 50*time.Millisecond),
+	)
+
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		_, _ = p.Do(req)
+	}()
+	for !p.conns.busy() {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	start := time.Now()
+	_, err := p.Do(req)
+	assert.True(t, errors.Is(err, ErrQueueTimeout))
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+
+	// the node rate is exhausted by the first request
+	p.conns = nil
+	req, _ = http.NewRequest(http.MethodGet, ts.URL, nil)
+	start = time.Now()
+	_, err = p.Do(req)
+	assert.True(t, errors.Is(err, limiter.ErrRateLimited))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
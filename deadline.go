@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+)
+
+// ErrDeadlineUnreachable is returned when no node is predicted to respond
+// before the request deadline.
+var ErrDeadlineUnreachable = errors.New("deadline_unreachable")
+
+// LatencyPredictor predicts the latency of a node, zero if unknown.
+type LatencyPredictor func(n selector.Node) time.Duration
+
+// EWMALatency predicts the latency by the moving average of the node latency, e.g. ewma.Node.
+func EWMALatency(n selector.Node) time.Duration {
+	if r, ok := n.(interface{ Lag() time.Duration }); ok {
+		return r.Lag()
+	}
+	return 0
+}
+
+// deadlineFilter drops the nodes predicted to miss the deadline of the request,
+// and counts the nodes kept by the last call.
+type deadlineFilter struct {
+	predict  LatencyPredictor
+	deadline time.Time
+	kept     int
+	dropped  int
+}
+
+func (f *deadlineFilter) filter(_ context.Context, nodes []selector.Node) []selector.Node {
+	remaining := time.Until(f.deadline)
+	filtered := make([]selector.Node, 0, len(nodes))
+	f.dropped = 0
+	for _, n := range nodes {
+		if f.predict(n) > remaining {
+			f.dropped++
+			continue
+		}
+		filtered = append(filtered, n)
+	}
+	f.kept = len(filtered)
+	return filtered
+}
+
+// unreachable reports whether the last call dropped all nodes.
+func (f *deadlineFilter) unreachable() bool {
+	return f != nil && f.kept == 0 && f.dropped > 0
+}
+
+// deadlineFilterOf returns the deadline filter of ctx, nil if disabled or ctx has no deadline.
+func (r *ReverseProxy) deadlineFilterOf(ctx context.Context) *deadlineFilter {
+	if r.predictLatency == nil {
+		return nil
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	return &deadlineFilter{predict: r.predictLatency, deadline: deadline}
+}
+
+// setTimeout sets the remaining time of the request in milliseconds to the timeout header.
+func (r *ReverseProxy) setTimeout(ctx context.Context, h http.Header) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	ms := time.Until(deadline).Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	h.Set(r.timeoutHeader, strconv.FormatInt(ms, 10))
+}
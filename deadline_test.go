@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+)
+
+func TestDeadlineAware(t *testing.T) {
+	var timeout string
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout = r.Header.Get("X-Request-Timeout-Ms")
+	}))
+	defer fast.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer slow.Close()
+
+	predicted := map[string]time.Duration{
+		fast.URL[7:]: time.Millisecond,
+		slow.URL[7:]: time.Second,
+	}
+	p := New(
+		WithInitialNodes([]selector.Node{
+			selector.NewNode("http", fast.URL[7:], nil),
+			selector.NewNode("http", slow.URL[7:], nil),
+		}),
+		WithDeadlineAware(func(n selector.Node) time.Duration {
+			return predicted[n.Address()]
+		}),
+		WithTimeoutHeader("X-Request-Timeout-Ms"),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, slow.URL, nil)
+		resp, err := p.Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+
+		ms, _ := strconv.Atoi(timeout)
+		assert.True(t, ms > 0 && ms <= 500, timeout)
+	}
+
+	predicted[fast.URL[7:]] = time.Second
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, slow.URL, nil)
+	_, err := p.Do(req)
+	assert.True(t, errors.Is(err, ErrDeadlineUnreachable))
+	assert.Equal(t, ClassDeadline, ErrorClass(err, 0))
+
+	// no deadline, no prediction
+	req, _ = http.NewRequest(http.MethodGet, slow.URL, nil)
+	resp, err := p.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"errors"
+
+	"github.com/omalloc/proxy/limiter"
+	"github.com/omalloc/proxy/selector"
+)
+
+// error classes of a failed upstream request, the classes of the upstream results are
+// shared with selector.ErrorClass.
+const (
+	ClassNoAvailable = selector.ClassNoAvailable
+	ClassRetryBudget = "retry_budget"
+	ClassLimited     = "limited"
+	ClassQueue       = "queue"
+	ClassRateLimited = "rate_limited"
+	ClassShed        = "shed"
+	ClassDeadline    = "deadline"
+	ClassCanceled    = selector.ClassCanceled
+	ClassTimeout     = selector.ClassTimeout
+	ClassNetwork     = selector.ClassNetwork
+	ClassUnknown     = selector.ClassUnknown
+	ClassThrottled   = selector.ClassThrottled
+	ClassServer      = selector.ClassServer
+)
+
+// ErrorClass returns the class of an upstream request result, empty on success.
+// The errors of this proxy are told apart before the results classed by selector.ErrorClass.
+func ErrorClass(err error, status int) string {
+	switch {
+	case errors.Is(err, ErrRetryBudgetExhausted):
+		return ClassRetryBudget
+	case errors.Is(err, limiter.ErrLimitExceeded):
+		return ClassLimited
+	case errors.Is(err, ErrQueueTimeout), errors.Is(err, ErrQueueFull):
+		return ClassQueue
+	case errors.Is(err, limiter.ErrRateLimited):
+		return ClassRateLimited
+	case errors.Is(err, ErrShed):
+		return ClassShed
+	case errors.Is(err, ErrDeadlineUnreachable):
+		return ClassDeadline
+	}
+	return selector.ErrorClass(err, status)
+}
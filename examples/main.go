@@ -38,6 +38,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	defer resp.Body.Close()
 
 	info, _ := httputil.DumpResponse(resp, false)
 	log.Printf("Response Info : \n%v", string(info))
@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+)
+
+const (
+	// defaultHedgeRatio is the default ratio of hedged requests.
+	defaultHedgeRatio = 0.1
+	// defaultHedgeDelay is the default delay, used by the percentile until enough latencies are observed.
+	defaultHedgeDelay = 100 * time.Millisecond
+	// hedgeWindow is the number of latency samples kept per node.
+	hedgeWindow = 128
+	// hedgeMinSamples is the samples required before the percentile delay is used.
+	hedgeMinSamples = 20
+)
+
+// errHedgeCanceled is the cause of canceling the attempt of a hedge which lost, its result
+// is no sample of the node.
+var errHedgeCanceled = fmt.Errorf("hedge_canceled: %w", selector.ErrDiscarded)
+
+// hedging sends a backup request to another node when the first one is slow.
+type hedging struct {
+	delay      time.Duration
+	percentile float64
+	budget     *Budget
+
+	mu        sync.Mutex
+	latencies map[string]*latencyWindow
+}
+
+func newHedging() *hedging {
+	return &hedging{
+		delay:     defaultHedgeDelay,
+		budget:    NewBudget(defaultHedgeRatio),
+		latencies: make(map[string]*latencyWindow),
+	}
+}
+
+// hedgeable reports whether req is idempotent and can be sent twice.
+func (h *hedging) hedgeable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	// the node is pinned by the peer in context
+	_, ok := selector.FromPeerContext(req.Context())
+	return !ok
+}
+
+// delayOf returns the hedge delay of the request sent to addr.
+func (h *hedging) delayOf(addr string) time.Duration {
+	if h.percentile <= 0 {
+		return h.delay
+	}
+	h.mu.Lock()
+	w, ok := h.latencies[addr]
+	h.mu.Unlock()
+	if !ok {
+		return h.delay
+	}
+	if d, ok := w.quantile(h.percentile); ok {
+		return d
+	}
+	return h.delay
+}
+
+// observe records the latency of a request sent to addr.
+func (h *hedging) observe(addr string, latency time.Duration) {
+	if h.percentile <= 0 {
+		return
+	}
+	h.mu.Lock()
+	w, ok := h.latencies[addr]
+	if !ok {
+		w = &latencyWindow{}
+		h.latencies[addr] = w
+	}
+	h.mu.Unlock()
+	w.add(latency)
+}
+
+// apply drops the latency windows of the removed nodes.
+func (h *hedging) apply(nodes []selector.Node) {
+	addrs := make(map[string]struct{}, len(nodes))
+	for _, n := range nodes {
+		addrs[n.Address()] = struct{}{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for addr := range h.latencies {
+		if _, ok := addrs[addr]; !ok {
+			delete(h.latencies, addr)
+		}
+	}
+}
+
+// latencyWindow keeps the latest latency samples of a node.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples [hedgeWindow]time.Duration
+	n       int
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.samples[w.n%hedgeWindow] = d
+	w.n++
+}
+
+func (w *latencyWindow) quantile(q float64) (time.Duration, bool) {
+	w.mu.Lock()
+	n := min(w.n, hedgeWindow)
+	if n < hedgeMinSamples {
+		w.mu.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, w.samples[:n])
+	w.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(q * float64(n-1))
+	return sorted[i], true
+}
+
+// hedgeResult is the result of a hedged attempt.
+type hedgeResult struct {
+	index  int
+	resp   *http.Response
+	addr   string
+	err    error
+	cancel context.CancelCauseFunc
+}
+
+func (res *hedgeResult) succeeded() bool {
+	return res.err == nil && ErrorClass(nil, res.resp.StatusCode) == ""
+}
+
+// discard releases the resources of a result which is not returned, the body is read
+// before the attempt is canceled so that a failure is reported as it is.
+func (res *hedgeResult) discard() {
+	if res.resp != nil {
+		discard(res.resp)
+	}
+	res.cancel(nil)
+}
+
+// release returns the result, its context is canceled after the body is closed.
+func (res *hedgeResult) release() (*http.Response, string, error) {
+	if res.resp == nil {
+		res.cancel(nil)
+		return nil, res.addr, res.err
+	}
+	res.resp.Body = &cancelBody{ReadCloser: res.resp.Body, cancel: func() { res.cancel(nil) }}
+	return res.resp, res.addr, res.err
+}
+
+// cancelBody cancels the request context on close.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// hedgeRoundTrip sends req to a node, and a backup request to another node if
+// the first one hasn't responded within the hedge delay. The first successful
+// response is returned and the other attempt is canceled.
+func (r *ReverseProxy) hedgeRoundTrip(req *http.Request, a attempt) (*http.Response, string, int, error) {
+	r.hedge.budget.Deposit()
+
+	var (
+		results  = make(chan *hedgeResult, 2)
+		selected = make(chan string, 1)
+		cancels  []context.CancelCauseFunc
+	)
+	launch := func(a attempt) {
+		ctx, cancel := context.WithCancelCause(req.Context())
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, addr, err := r.roundTrip(req.WithContext(ctx), a)
+			results <- &hedgeResult{index: index, resp: resp, addr: addr, err: err, cancel: cancel}
+		}()
+	}
+
+	launch(attempt{
+		retry:    a.retry,
+		exclude:  a.exclude,
+		selected: func(addr string) { selected <- addr },
+	})
+
+	var first string
+	select {
+	case first = <-selected:
+	case res := <-results:
+		// no node selected
+		resp, addr, err := res.release()
+		return resp, addr, 1, err
+	}
+
+	timer := time.NewTimer(r.hedge.delayOf(first))
+	defer timer.Stop()
+
+	var (
+		inflight = 1
+		failed   *hedgeResult
+	)
+	for {
+		select {
+		case <-timer.C:
+			exclude := append(slices.Clip(a.exclude), first)
+			if size := r.poolSize(); (size < 0 || size > len(exclude)) && r.hedge.budget.Withdraw() {
+				launch(attempt{retry: a.retry + 1, exclude: exclude})
+				inflight++
+			}
+		case res := <-results:
+			inflight--
+			if !res.succeeded() && inflight > 0 {
+				// wait for the other attempt, keep the first failure as fallback
+				if failed == nil {
+					failed = res
+				} else {
+					res.discard()
+				}
+				continue
+			}
+			if !res.succeeded() && failed != nil && (failed.resp != nil || res.resp == nil && failed.addr != "") {
+				// all attempts failed, return the most informative failure
+				res, failed = failed, res
+			}
+			if failed != nil {
+				failed.discard()
+			}
+			if inflight > 0 {
+				// cancel the loser and release its response
+				for i, cancel := range cancels {
+					if i != res.index {
+						cancel(errHedgeCanceled)
+					}
+				}
+				go func() {
+					(<-results).discard()
+				}()
+			}
+			resp, addr, err := res.release()
+			return resp, addr, len(cancels), err
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/wrr"
+)
+
+func newHedgeServers(t *testing.T) (slow, fast *httptest.Server, canceled chan struct{}) {
+	canceled = make(chan struct{}, 1)
+	slow = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			canceled <- struct{}{}
+		case <-time.After(300 * time.Millisecond):
+			w.Header().Set("Via", "slow")
+		}
+	}))
+	fast = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Via", "fast")
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(fast.Close)
+	return
+}
+
+func TestHedging(t *testing.T) {
+	slow, fast, canceled := newHedgeServers(t)
+	dones := make(chan selector.DoneInfo, 1)
+
+	p := New(
+		WithSelector(wrr.New()),
+		WithInitialNodes([]selector.Node{
+			&mockNode{scheme: "http", addr: slow.URL[7:]},
+			&mockNode{scheme: "http", addr: fast.URL[7:]},
+		}),
+		WithHedgeDelay(20*time.Millisecond),
+		WithHedgeBudget(NewBudget(1)),
+		WithObserver(selector.Observer{
+			OnDone: func(_ context.Context, n selector.Node, di selector.DoneInfo, _ time.Duration) {
+				if n.Address() == slow.URL[7:] {
+					dones <- di
+				}
+			},
+		}),
+	)
+
+	req, _ := http.NewRequest(http.MethodGet, slow.URL, nil)
+	start := time.Now()
+	resp, err := p.Do(req)
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 300*time.Millisecond)
+	assert.Equal(t, "fast", resp.Header.Get("Via"))
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.NoError(t, resp.Body.Close())
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the slow attempt is not canceled")
+	}
+	// the loser is no failure of the node
+	di := <-dones
+	assert.True(t, errors.Is(di.Err, selector.ErrDiscarded))
+}
+
+func TestHedgingPercentileDelay(t *testing.T) {
+	p := New(WithHedgePercentile(0.95))
+	assert.Equal(t, defaultHedgeDelay, p.hedge.delayOf("127.0.0.1:8080"))
+
+	for i := 0; i < hedgeMinSamples; i++ {
+		p.hedge.observe("127.0.0.1:8080", time.Millisecond)
+	}
+	assert.Equal(t, time.Millisecond, p.hedge.delayOf("127.0.0.1:8080"))
+}
+
+func TestHedgingLatencies(t *testing.T) {
+	slow, fast, canceled := newHedgeServers(t)
+	nodes := []selector.Node{
+		&mockNode{scheme: "http", addr: slow.URL[7:]},
+		&mockNode{scheme: "http", addr: fast.URL[7:]},
+	}
+	p := New(
+		WithSelector(wrr.New()),
+		WithInitialNodes(nodes),
+		WithHedgeDelay(20*time.Millisecond),
+		WithHedgePercentile(0.95),
+		WithHedgeBudget(NewBudget(1)),
+	)
+
+	req, _ := http.NewRequest(http.MethodGet, slow.URL, nil)
+	resp, err := p.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", resp.Header.Get("Via"))
+	_ = resp.Body.Close()
+	<-canceled
+
+	// the canceled loser is no sample
+	p.hedge.mu.Lock()
+	assert.Contains(t, p.hedge.latencies, fast.URL[7:])
+	assert.NotContains(t, p.hedge.latencies, slow.URL[7:])
+	p.hedge.mu.Unlock()
+
+	// the windows of the removed nodes are dropped
+	p.Apply(nodes[:1])
+	p.hedge.mu.Lock()
+	assert.Empty(t, p.hedge.latencies)
+	p.hedge.mu.Unlock()
+}
+
+func TestHedgingBudget(t *testing.T) {
+	slow, fast, _ := newHedgeServers(t)
+
+	p := New(
+		WithSelector(wrr.New()),
+		WithInitialNodes([]selector.Node{
+			&mockNode{scheme: "http", addr: slow.URL[7:]},
+			&mockNode{scheme: "http", addr: fast.URL[7:]},
+		}),
+		WithHedgeDelay(20*time.Millisecond),
+		WithHedgeBudget(NewBudget(0)),
+	)
+
+	req, _ := http.NewRequest(http.MethodGet, slow.URL, nil)
+	resp, err := p.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "slow", resp.Header.Get("Via"))
+	_ = resp.Body.Close()
+}
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(0.5)
+	assert.False(t, b.Withdraw())
+
+	b.Deposit()
+	assert.False(t, b.Withdraw())
+	b.Deposit()
+	assert.True(t, b.Withdraw())
+	assert.False(t, b.Withdraw())
+
+	var nilBudget *Budget
+	nilBudget.Deposit()
+	assert.False(t, nilBudget.Withdraw())
+}
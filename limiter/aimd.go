@@ -0,0 +1,75 @@
+package limiter
+
+import (
+	"math"
+	"time"
+)
+
+var _ Algorithm = (*AIMD)(nil)
+
+// AIMDOption is aimd option.
+type AIMDOption func(a *AIMD)
+
+// WithAIMDLimits set the initial, min and max limits, default is 20, 1 and 1000.
+func WithAIMDLimits(initial, min, max int) AIMDOption {
+	return func(a *AIMD) {
+		a.limit = float64(initial)
+		a.min = float64(min)
+		a.max = float64(max)
+	}
+}
+
+// WithAIMDBackoff set the ratio (0, 1) the limit is multiplied by on drop, default is 0.9.
+func WithAIMDBackoff(ratio float64) AIMDOption {
+	return func(a *AIMD) {
+		a.backoff = ratio
+	}
+}
+
+// WithAIMDTimeout set the latency treated as drop, default is 0 which disables it.
+func WithAIMDTimeout(d time.Duration) AIMDOption {
+	return func(a *AIMD) {
+		a.timeout = d
+	}
+}
+
+// AIMD is the additive increase multiplicative decrease algorithm: the limit grows by one
+// on success while it is utilized, and is multiplied by the backoff ratio on drop.
+type AIMD struct {
+	limit   float64
+	min     float64
+	max     float64
+	backoff float64
+	timeout time.Duration
+}
+
+// NewAIMD create an aimd algorithm.
+func NewAIMD(opts ...AIMDOption) *AIMD {
+	a := &AIMD{
+		limit:   20,
+		min:     1,
+		max:     1000,
+		backoff: 0.9,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Limit is the current concurrency limit.
+func (a *AIMD) Limit() int {
+	return int(a.limit)
+}
+
+// Update the limit with a sample.
+func (a *AIMD) Update(rtt time.Duration, inflight int, dropped bool) {
+	if dropped || (a.timeout > 0 && rtt > a.timeout) {
+		a.limit = math.Max(a.min, math.Floor(a.limit*a.backoff))
+		return
+	}
+	// only grow when the limit is utilized
+	if float64(inflight)*2 >= a.limit {
+		a.limit = math.Min(a.max, a.limit+1)
+	}
+}
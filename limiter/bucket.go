@@ -0,0 +1,138 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a token isn't available within the max wait.
+var ErrRateLimited = errors.New("rate_limited")
+
+// Bucket is a token bucket, tokens are refilled at rate per second up to burst.
+//
+// All methods are safe to call on a nil *Bucket, which never limits.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket create a full bucket, burst <= 0 is the rate rounded up.
+func NewBucket(rate float64, burst int) *Bucket {
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	return &Bucket{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+		last:   time.Now(),
+	}
+}
+
+// advance refills the tokens up to now, must be called with b.mu held.
+func (b *Bucket) advance(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// Allow takes a token if one is available.
+func (b *Bucket) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Delay is the time until a token is available, zero if one is available now.
+func (b *Bucket) Delay() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	return b.delay()
+}
+
+// delay must be called with b.mu held.
+func (b *Bucket) delay() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	if b.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Full reports whether the bucket is full, a full bucket is the same as a new one.
+func (b *Bucket) Full() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	return b.tokens >= b.burst
+}
+
+// Wait takes a token, waits up to max for it if none is available.
+// It fails with ErrRateLimited without waiting if the token isn't available within max,
+// or ctx is done before.
+func (b *Bucket) Wait(ctx context.Context, max time.Duration) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	b.advance(time.Now())
+	delay := b.delay()
+	if delay == 0 {
+		b.tokens--
+		b.mu.Unlock()
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		b.mu.Unlock()
+		return ErrRateLimited
+	}
+	if delay > max {
+		b.mu.Unlock()
+		return ErrRateLimited
+	}
+	// reserve the token ahead, later callers wait behind it
+	b.tokens--
+	b.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.advance(time.Now())
+		b.tokens = math.Min(b.burst, b.tokens+1)
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
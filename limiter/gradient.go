@@ -0,0 +1,106 @@
+package limiter
+
+import (
+	"math"
+	"time"
+)
+
+var _ Algorithm = (*Gradient)(nil)
+
+// GradientOption is gradient option.
+type GradientOption func(g *Gradient)
+
+// WithGradientLimits set the initial, min and max limits, default is 20, 1 and 1000.
+func WithGradientLimits(initial, min, max int) GradientOption {
+	return func(g *Gradient) {
+		g.limit = float64(initial)
+		g.min = float64(min)
+		g.max = float64(max)
+	}
+}
+
+// WithGradientTolerance set how much the latency may exceed the baseline before
+// the limit decreases, default is 1.5.
+func WithGradientTolerance(tolerance float64) GradientOption {
+	return func(g *Gradient) {
+		g.tolerance = tolerance
+	}
+}
+
+// WithGradientSmoothing set the weight (0, 1] of a new limit, default is 0.2.
+func WithGradientSmoothing(smoothing float64) GradientOption {
+	return func(g *Gradient) {
+		g.smoothing = smoothing
+	}
+}
+
+// Gradient is a delay based algorithm in the spirit of TCP Vegas: it keeps a long-term
+// average of the latency as the no-load baseline, and scales the limit by the ratio of
+// the baseline to the latest latency, plus a small queue allowance to probe for more.
+type Gradient struct {
+	limit     float64
+	min       float64
+	max       float64
+	tolerance float64
+	smoothing float64
+
+	// baseline is the long-term moving average of the latency.
+	baseline float64
+	samples  int
+}
+
+// longWindow is the samples the baseline averages over.
+const longWindow = 600
+
+// NewGradient create a gradient algorithm.
+func NewGradient(opts ...GradientOption) *Gradient {
+	g := &Gradient{
+		limit:     20,
+		min:       1,
+		max:       1000,
+		tolerance: 1.5,
+		smoothing: 0.2,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Limit is the current concurrency limit.
+func (g *Gradient) Limit() int {
+	return int(g.limit)
+}
+
+// Update the limit with a sample.
+func (g *Gradient) Update(rtt time.Duration, inflight int, dropped bool) {
+	short := float64(rtt)
+	if short <= 0 {
+		return
+	}
+	// warm up with a simple average, then switch to the exponential one
+	g.samples++
+	if g.samples < longWindow {
+		g.baseline += (short - g.baseline) / float64(g.samples)
+	} else {
+		g.baseline += (short - g.baseline) * 2 / (longWindow + 1)
+	}
+	// the baseline drifts up under sustained load, pull it back when the latency recovers
+	if g.baseline > short*2 {
+		g.baseline = short * 2
+	}
+
+	// don't grow the limit when it isn't utilized
+	if !dropped && float64(inflight) < g.limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, g.tolerance*g.baseline/short))
+	if dropped {
+		gradient = 0.5
+	}
+	queue := math.Sqrt(g.limit)
+	next := g.limit*gradient + queue
+	next = g.limit*(1-g.smoothing) + next*g.smoothing
+	g.limit = math.Max(g.min, math.Min(g.max, next))
+}
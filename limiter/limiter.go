@@ -0,0 +1,221 @@
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLimitExceeded is returned when the concurrency limit is reached and the request can't be queued.
+var ErrLimitExceeded = errors.New("concurrency_limit_exceeded")
+
+// Algorithm learns the concurrency limit from the observed samples.
+//
+// The methods are called with the limiter locked, implementations need no extra locking
+// as long as an instance is used by a single limiter.
+type Algorithm interface {
+	// Limit is the current concurrency limit.
+	Limit() int
+	// Update the limit with a sample, inflight is the requests in flight when the sample
+	// is taken and dropped reports the request is timed out or rejected by upstream.
+	Update(rtt time.Duration, inflight int, dropped bool)
+}
+
+// Option is limiter option.
+type Option func(o *options)
+
+// options is limiter options
+type options struct {
+	maxQueue     int
+	queueTimeout time.Duration
+}
+
+// WithMaxQueue set the max requests waiting for a slot, default is 0 which rejects
+// requests immediately once the limit is reached.
+func WithMaxQueue(n int) Option {
+	return func(o *options) {
+		o.maxQueue = n
+	}
+}
+
+// WithQueueTimeout set the max time a request waits for a slot, 0 waits until the request context is done.
+func WithQueueTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.queueTimeout = d
+	}
+}
+
+// Limiter limits the requests in flight with an adaptive algorithm.
+//
+// All methods are safe to call on a nil *Limiter, which never limits.
+type Limiter struct {
+	mu       sync.Mutex
+	alg      Algorithm
+	opts     options
+	inflight int
+	waiters  list.List
+}
+
+// New create a limiter with the algorithm alg.
+func New(alg Algorithm, opts ...Option) *Limiter {
+	l := &Limiter{alg: alg}
+	for _, opt := range opts {
+		opt(&l.opts)
+	}
+	return l
+}
+
+// Acquire takes a slot, waits in the queue if the limit is reached.
+// The returned token must be released by Done or Cancel.
+func (l *Limiter) Acquire(ctx context.Context) (*Token, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	if l.inflight < l.alg.Limit() {
+		l.inflight++
+		l.mu.Unlock()
+		return &Token{l: l}, nil
+	}
+	if l.waiters.Len() >= l.opts.maxQueue {
+		l.mu.Unlock()
+		return nil, ErrLimitExceeded
+	}
+	// the slot is handed over by closing ready
+	ready := make(chan struct{})
+	e := l.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if l.opts.queueTimeout > 0 {
+		timer := time.NewTimer(l.opts.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-ready:
+		return &Token{l: l}, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrLimitExceeded
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// handed over concurrently, give it back
+		l.release()
+	default:
+		l.waiters.Remove(e)
+	}
+	return nil, err
+}
+
+// TryAcquire takes a slot if the limit isn't reached, it never waits.
+// The returned token must be released by Done or Cancel.
+func (l *Limiter) TryAcquire() (*Token, bool) {
+	if l == nil {
+		return nil, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inflight >= l.alg.Limit() {
+		return nil, false
+	}
+	l.inflight++
+	return &Token{l: l}, true
+}
+
+// Available reports whether a slot can be taken without waiting.
+func (l *Limiter) Available() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.inflight < l.alg.Limit()
+}
+
+// Limit is the current concurrency limit.
+func (l *Limiter) Limit() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.alg.Limit()
+}
+
+// Inflight is the requests in flight.
+func (l *Limiter) Inflight() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.inflight
+}
+
+// Queued is the requests waiting for a slot.
+func (l *Limiter) Queued() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.waiters.Len()
+}
+
+// release must be called with l.mu held.
+func (l *Limiter) release() {
+	l.inflight--
+	for l.waiters.Len() > 0 && l.inflight < l.alg.Limit() {
+		ready := l.waiters.Remove(l.waiters.Front()).(chan struct{})
+		l.inflight++
+		close(ready)
+	}
+}
+
+// Token is a slot taken from a limiter.
+//
+// All methods are safe to call on a nil *Token and only the first call takes effect.
+type Token struct {
+	l        *Limiter
+	released int32
+}
+
+// Done releases the slot and feeds the sample to the algorithm.
+func (t *Token) Done(rtt time.Duration, dropped bool) {
+	if t == nil || !atomic.CompareAndSwapInt32(&t.released, 0, 1) {
+		return
+	}
+	t.l.mu.Lock()
+	defer t.l.mu.Unlock()
+
+	t.l.alg.Update(rtt, t.l.inflight, dropped)
+	t.l.release()
+}
+
+// Cancel releases the slot without a sample, e.g. the request is never sent.
+func (t *Token) Cancel() {
+	if t == nil || !atomic.CompareAndSwapInt32(&t.released, 0, 1) {
+		return
+	}
+	t.l.mu.Lock()
+	defer t.l.mu.Unlock()
+
+	t.l.release()
+}
@@ -0,0 +1,137 @@
+package limiter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/limiter"
+)
+
+func TestLimiterReject(t *testing.T) {
+	l := limiter.New(limiter.NewAIMD(limiter.WithAIMDLimits(2, 1, 10)))
+
+	t1, err := l.Acquire(context.Background())
+	assert.NoError(t, err)
+	t2, err := l.Acquire(context.Background())
+	assert.NoError(t, err)
+	_, err = l.Acquire(context.Background())
+	assert.Equal(t, limiter.ErrLimitExceeded, err)
+	assert.Equal(t, 2, l.Inflight())
+
+	t1.Done(time.Millisecond, false)
+	t1.Done(time.Millisecond, false)
+	t2.Cancel()
+	assert.Equal(t, 0, l.Inflight())
+	assert.Equal(t, 3, l.Limit())
+}
+
+func TestLimiterTryAcquire(t *testing.T) {
+	l := limiter.New(limiter.NewAIMD(limiter.WithAIMDLimits(1, 1, 1)), limiter.WithMaxQueue(1))
+
+	assert.True(t, l.Available())
+	t1, ok := l.TryAcquire()
+	assert.True(t, ok)
+	assert.False(t, l.Available())
+	_, ok = l.TryAcquire()
+	assert.False(t, ok)
+	t1.Cancel()
+	assert.True(t, l.Available())
+
+	var nilLimiter *limiter.Limiter
+	assert.True(t, nilLimiter.Available())
+	_, ok = nilLimiter.TryAcquire()
+	assert.True(t, ok)
+}
+
+func TestLimiterQueue(t *testing.T) {
+	l := limiter.New(limiter.NewAIMD(limiter.WithAIMDLimits(1, 1, 1)),
+		limiter.WithMaxQueue(1),
+		limiter.WithQueueTimeout(50*time.Millisecond),
+	)
+
+	t1, err := l.Acquire(context.Background())
+	assert.NoError(t, err)
+
+	acquired := make(chan *limiter.Token)
+	go func() {
+		t2, _ := l.Acquire(context.Background())
+		acquired <- t2
+	}()
+	for l.Queued() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// the queue is full
+	_, err = l.Acquire(context.Background())
+	assert.Equal(t, limiter.ErrLimitExceeded, err)
+
+	t1.Done(time.Millisecond, false)
+	t2 := <-acquired
+	assert.NotNil(t, t2)
+	assert.Equal(t, 1, l.Inflight())
+
+	// times out in the queue
+	_, err = l.Acquire(context.Background())
+	assert.Equal(t, limiter.ErrLimitExceeded, err)
+	assert.Equal(t, 0, l.Queued())
+	t2.Done(time.Millisecond, false)
+	assert.Equal(t, 0, l.Inflight())
+}
+
+func TestAIMD(t *testing.T) {
+	a := limiter.NewAIMD(limiter.WithAIMDLimits(10, 1, 20), limiter.WithAIMDTimeout(100*time.Millisecond))
+
+	a.Update(time.Millisecond, 1, false)
+	assert.Equal(t, 10, a.Limit(), "not utilized")
+	a.Update(time.Millisecond, 5, false)
+	assert.Equal(t, 11, a.Limit())
+	a.Update(time.Millisecond, 5, true)
+	assert.Equal(t, 9, a.Limit())
+	a.Update(time.Second, 5, false)
+	assert.Equal(t, 8, a.Limit())
+}
+
+func TestGradient(t *testing.T) {
+	g := limiter.NewGradient(limiter.WithGradientLimits(20, 1, 100))
+
+	for i := 0; i < 100; i++ {
+		g.Update(10*time.Millisecond, g.Limit(), false)
+	}
+	grown := g.Limit()
+	assert.Greater(t, grown, 20)
+
+	for i := 0; i < 20; i++ {
+		g.Update(100*time.Millisecond, g.Limit(), false)
+	}
+	assert.Less(t, g.Limit(), grown)
+
+	var nilLimiter *limiter.Limiter
+	token, err := nilLimiter.Acquire(context.Background())
+	assert.NoError(t, err)
+	token.Done(time.Millisecond, false)
+}
+
+func TestBucket(t *testing.T) {
+	b := limiter.NewBucket(20, 2)
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+	assert.False(t, b.Full())
+	assert.InDelta(t, 50*time.Millisecond, b.Delay(), float64(10*time.Millisecond))
+
+	// the token comes in 50ms
+	assert.Equal(t, limiter.ErrRateLimited, b.Wait(context.Background(), 10*time.Millisecond))
+	start := time.Now()
+	assert.NoError(t, b.Wait(context.Background(), time.Second))
+	assert.Greater(t, time.Since(start), 30*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, b.Wait(ctx, time.Second))
+
+	var nop *limiter.Bucket
+	assert.True(t, nop.Allow())
+	assert.NoError(t, nop.Wait(context.Background(), 0))
+}
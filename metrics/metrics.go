@@ -0,0 +1,359 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+)
+
+const (
+	// ContentType is the content type of the prometheus text exposition format.
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	defaultNamespace = "proxy"
+)
+
+var _ http.Handler = (*Metrics)(nil)
+
+// EWMAReporter is implemented by weighted nodes which collect ewma statistic, e.g. ewma.Node.
+type EWMAReporter interface {
+	// Lag is the moving average of the latency.
+	Lag() time.Duration
+	// Success is the moving average of the success ratio in [0, 1].
+	Success() float64
+}
+
+// UtilizationReporter is implemented by weighted nodes which know the utilization reported
+// by the backend, e.g. orca.Node.
+type UtilizationReporter interface {
+	// Utilization is the utilization in [0, 1].
+	Utilization() float64
+}
+
+// HealthReporter is implemented by weighted nodes which know their health state,
+// e.g. ewma.Node and orca.Node.
+type HealthReporter interface {
+	Healthy() bool
+}
+
+// Option is metrics option.
+type Option func(o *options)
+
+// options is metrics options
+type options struct {
+	namespace string
+	buckets   []float64
+}
+
+// WithNamespace set the prefix of all metric names, default is "proxy".
+func WithNamespace(ns string) Option {
+	return func(o *options) {
+		o.namespace = ns
+	}
+}
+
+// WithBuckets set the latency histogram buckets in seconds, default is DefBuckets.
+func WithBuckets(buckets []float64) Option {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+// Metrics collects the metrics of proxies and their selectors,
+// and exposes them in the prometheus text format.
+type Metrics struct {
+	mu    sync.Mutex
+	pools map[string]*Pool
+
+	requests    *valueVec
+	errors      *valueVec
+	canceled    *valueVec
+	noAvailable *valueVec
+	inflight    *valueVec
+	latency     *histogramVec
+	queueDepth  *valueVec
+	queueWait   *histogramVec
+	phases      *histogramVec
+	connections *valueVec
+
+	// collected on scrape
+	poolSize    *valueVec
+	weight      *valueVec
+	ewmaLag     *valueVec
+	ewmaSuccess *valueVec
+	utilization *valueVec
+	healthy     *valueVec
+}
+
+// New create a metrics collector.
+func New(opts ...Option) *Metrics {
+	o := options{
+		namespace: defaultNamespace,
+		buckets:   DefBuckets,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	name := func(s string) string {
+		if o.namespace == "" {
+			return s
+		}
+		return o.namespace + "_" + s
+	}
+	return &Metrics{
+		pools:       make(map[string]*Pool),
+		requests:    newCounterVec(name("requests_total"), "Total number of requests sent to upstream nodes.", "pool", "node", "code"),
+		errors:      newCounterVec(name("errors_total"), "Total number of failed requests by error class.", "pool", "node", "class"),
+		canceled:    newCounterVec(name("requests_canceled_total"), "Total number of requests canceled as their result is not needed, e.g. the slower one of hedged requests.", "pool", "node"),
+		noAvailable: newCounterVec(name("no_available_total"), "Total number of requests failed without any available node.", "pool"),
+		inflight:    newGaugeVec(name("inflight_requests"), "Number of requests in flight.", "pool", "node"),
+		latency:     newHistogramVec(name("request_duration_seconds"), "Upstream request latency in seconds.", o.buckets, "pool", "node"),
+		queueDepth:  newGaugeVec(name("queue_depth"), "Number of requests waiting for a node under its max in-flight cap.", "pool"),
+		queueWait:   newHistogramVec(name("queue_wait_seconds"), "Time waited for a node under its max in-flight cap in seconds.", o.buckets, "pool"),
+		phases:      newHistogramVec(name("request_phase_duration_seconds"), "Duration of the request phases (dns, connect, tls, ttfb) in seconds.", o.buckets, "pool", "node", "phase"),
+		connections: newCounterVec(name("connections_total"), "Total number of requests by whether the connection is reused.", "pool", "node", "reused"),
+		poolSize:    newGaugeVec(name("pool_size"), "Number of nodes in the pool.", "pool"),
+		weight:      newGaugeVec(name("node_weight"), "Current scheduling weight of the node.", "pool", "node"),
+		ewmaLag:     newGaugeVec(name("node_ewma_lag_seconds"), "Moving average of the node latency in seconds.", "pool", "node"),
+		ewmaSuccess: newGaugeVec(name("node_ewma_success_ratio"), "Moving average of the node success ratio.", "pool", "node"),
+		utilization: newGaugeVec(name("node_utilization"), "Utilization reported by the node.", "pool", "node"),
+		healthy:     newGaugeVec(name("node_healthy"), "Whether the node is healthy (1) or not (0).", "pool", "node"),
+	}
+}
+
+// Pool returns the recorder of the named pool, the same name returns the same recorder.
+func (m *Metrics) Pool(name string) *Pool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if p, ok := m.pools[name]; ok {
+		return p
+	}
+	p := &Pool{m: m, name: name}
+	m.pools[name] = p
+	return p
+}
+
+// ServeHTTP writes all metrics in the prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.collect()
+
+	w.Header().Set("Content-Type", ContentType)
+	_ = writeText(w, []family{
+		m.requests, m.errors, m.canceled, m.noAvailable, m.inflight, m.latency, m.queueDepth, m.queueWait,
+		m.phases, m.connections,
+		m.poolSize, m.weight, m.ewmaLag, m.ewmaSuccess, m.utilization, m.healthy,
+	})
+}
+
+// collect refresh the gauges read from the selectors.
+func (m *Metrics) collect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, vv := range []*valueVec{m.poolSize, m.weight, m.ewmaLag, m.ewmaSuccess, m.utilization, m.healthy} {
+		vv.reset()
+	}
+	for name, p := range m.pools {
+		lister := p.nodeLister()
+		if lister == nil {
+			continue
+		}
+		nodes := lister.Nodes()
+		m.poolSize.with(name).set(float64(len(nodes)))
+		for _, n := range nodes {
+			addr := n.Address()
+			m.weight.with(name, addr).set(n.Weight())
+			if r, ok := n.(EWMAReporter); ok {
+				m.ewmaLag.with(name, addr).set(r.Lag().Seconds())
+				m.ewmaSuccess.with(name, addr).set(r.Success())
+			}
+			if r, ok := n.(UtilizationReporter); ok {
+				m.utilization.with(name, addr).set(r.Utilization())
+			}
+			if r, ok := n.(HealthReporter); ok {
+				healthy := 0.0
+				if r.Healthy() {
+					healthy = 1
+				}
+				m.healthy.with(name, addr).set(healthy)
+			}
+		}
+	}
+}
+
+// Pool records the metrics of a single pool.
+//
+// All methods are safe to call on a nil *Pool, which records nothing.
+type Pool struct {
+	m    *Metrics
+	name string
+
+	mu     sync.Mutex
+	lister selector.NodeLister
+}
+
+// Watch reads the node gauges from s on every scrape if s implements selector.NodeLister.
+func (p *Pool) Watch(s selector.Selector) {
+	if p == nil {
+		return
+	}
+	lister, _ := s.(selector.NodeLister)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lister = lister
+}
+
+func (p *Pool) nodeLister() selector.NodeLister {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.lister
+}
+
+// Apply drops the series of the nodes which left the pool, the requests in flight of
+// a node are kept until they are done.
+func (p *Pool) Apply(nodes []selector.Node) {
+	if p == nil {
+		return
+	}
+	addrs := make(map[string]struct{}, len(nodes))
+	for _, n := range nodes {
+		addrs[n.Address()] = struct{}{}
+	}
+	removed := func(labels []string) bool {
+		if labels[0] != p.name {
+			return false
+		}
+		_, ok := addrs[labels[1]]
+		return !ok
+	}
+	for _, vv := range []*valueVec{p.m.requests, p.m.errors, p.m.canceled, p.m.connections} {
+		vv.drop(func(labels []string, _ float64) bool { return removed(labels) })
+	}
+	p.m.inflight.drop(func(labels []string, v float64) bool { return v == 0 && removed(labels) })
+	for _, hv := range []*histogramVec{p.m.latency, p.m.phases} {
+		hv.drop(removed)
+	}
+}
+
+// Start records a request sent to node.
+func (p *Pool) Start(node string) {
+	if p == nil {
+		return
+	}
+	p.m.inflight.with(p.name, node).add(1)
+}
+
+// Done records a request to node finished, status is zero if no response received,
+// class is the error class, empty on success.
+func (p *Pool) Done(node string, status int, class string, latency time.Duration) {
+	if p == nil {
+		return
+	}
+	code := "error"
+	if status > 0 {
+		code = strconv.Itoa(status)
+	}
+	p.m.inflight.with(p.name, node).add(-1)
+	p.m.requests.with(p.name, node, code).add(1)
+	p.m.latency.observe(latency.Seconds(), p.name, node)
+	if class != "" {
+		p.m.errors.with(p.name, node, class).add(1)
+	}
+}
+
+// Cancel records a request to node canceled as its result is not needed, e.g. the slower
+// one of hedged requests, it is neither a success nor a failure.
+func (p *Pool) Cancel(node string) {
+	if p == nil {
+		return
+	}
+	p.m.inflight.with(p.name, node).add(-1)
+	p.m.canceled.with(p.name, node).add(1)
+}
+
+// Observer returns an observer recording the requests, errors and latency of the nodes picked
+// by a selector used without proxy.ReverseProxy, which records them itself, e.g.
+//
+//	s.(selector.Observable).Observe(pool.Observer())
+func (p *Pool) Observer() selector.Observer {
+	return selector.Observer{
+		OnSelect: func(_ context.Context, node selector.Node) {
+			p.Start(node.Address())
+		},
+		OnDone: func(_ context.Context, node selector.Node, di selector.DoneInfo, latency time.Duration) {
+			if errors.Is(di.Err, selector.ErrDiscarded) {
+				p.Cancel(node.Address())
+				return
+			}
+			p.Done(node.Address(), di.StatusCode, classOf(di), latency)
+		},
+		OnNoAvailable: func(context.Context) {
+			p.NoAvailable()
+		},
+		OnApply: func(_, nodes []selector.Node) {
+			p.Apply(nodes)
+		},
+	}
+}
+
+// classOf returns the error class of an upstream result, empty on success.
+func classOf(di selector.DoneInfo) string {
+	if di.Err == nil && di.ReadErr != nil {
+		return selector.ErrorClass(di.ReadErr, 0)
+	}
+	return selector.ErrorClass(di.Err, di.StatusCode)
+}
+
+// Timing records the connection phases and the time to first byte of a request to node.
+func (p *Pool) Timing(node string, t selector.Timing, ttfb time.Duration) {
+	if p == nil {
+		return
+	}
+	for _, phase := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"dns", t.DNS},
+		{"connect", t.Connect},
+		{"tls", t.TLSHandshake},
+	} {
+		if phase.d > 0 {
+			p.m.phases.observe(phase.d.Seconds(), p.name, node, phase.name)
+		}
+	}
+	p.m.phases.observe(ttfb.Seconds(), p.name, node, "ttfb")
+	p.m.connections.with(p.name, node, strconv.FormatBool(t.ConnReused)).add(1)
+}
+
+// NoAvailable records a request failed without any available node.
+func (p *Pool) NoAvailable() {
+	if p == nil {
+		return
+	}
+	p.m.noAvailable.with(p.name).add(1)
+}
+
+// QueueDepth records the number of requests waiting for a node.
+func (p *Pool) QueueDepth(n int) {
+	if p == nil {
+		return
+	}
+	p.m.queueDepth.with(p.name).set(float64(n))
+}
+
+// QueueWait records the time a request waited for a node.
+func (p *Pool) QueueWait(wait time.Duration) {
+	if p == nil {
+		return
+	}
+	p.m.queueWait.observe(wait.Seconds(), p.name)
+}
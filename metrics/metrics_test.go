@@ -0,0 +1,108 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/metrics"
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/p2c"
+)
+
+func scrape(m *metrics.Metrics) string {
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	m := metrics.New(metrics.WithBuckets([]float64{0.1, 1}))
+
+	s := p2c.New()
+	s.Apply([]selector.Node{
+		selector.NewNode("http", "127.0.0.1:8080", nil),
+		selector.NewNode("http", "127.0.0.1:8081", nil),
+	})
+	p := m.Pool("api")
+	p.Watch(s)
+
+	_, done, err := s.Select(context.Background())
+	assert.NoError(t, err)
+	done(context.Background(), selector.DoneInfo{Err: errors.New("reset")})
+
+	p.Start("127.0.0.1:8080")
+	p.Done("127.0.0.1:8080", http.StatusOK, "", 50*time.Millisecond)
+	p.Start("127.0.0.1:8080")
+	p.Done("127.0.0.1:8080", http.StatusBadGateway, "server", 500*time.Millisecond)
+	p.Start("127.0.0.1:8080")
+	p.Cancel("127.0.0.1:8080")
+	p.Timing("127.0.0.1:8080", selector.Timing{Connect: 5 * time.Millisecond}, 50*time.Millisecond)
+	p.NoAvailable()
+
+	text := scrape(m)
+	assert.Contains(t, text, "# TYPE proxy_requests_total counter\n")
+	assert.Contains(t, text, `proxy_requests_total{pool="api",node="127.0.0.1:8080",code="200"} 1`)
+	assert.Contains(t, text, `proxy_requests_total{pool="api",node="127.0.0.1:8080",code="502"} 1`)
+	assert.Contains(t, text, `proxy_errors_total{pool="api",node="127.0.0.1:8080",class="server"} 1`)
+	assert.Contains(t, text, `proxy_requests_canceled_total{pool="api",node="127.0.0.1:8080"} 1`)
+	assert.Contains(t, text, `proxy_no_available_total{pool="api"} 1`)
+	assert.Contains(t, text, `proxy_inflight_requests{pool="api",node="127.0.0.1:8080"} 0`)
+	assert.Contains(t, text, `proxy_request_duration_seconds_bucket{pool="api",node="127.0.0.1:8080",le="0.1"} 1`)
+	assert.Contains(t, text, `proxy_request_duration_seconds_bucket{pool="api",node="127.0.0.1:8080",le="1"} 2`)
+	assert.Contains(t, text, `proxy_request_duration_seconds_bucket{pool="api",node="127.0.0.1:8080",le="+Inf"} 2`)
+	assert.Contains(t, text, `proxy_request_duration_seconds_count{pool="api",node="127.0.0.1:8080"} 2`)
+	assert.Contains(t, text, `proxy_request_phase_duration_seconds_count{pool="api",node="127.0.0.1:8080",phase="connect"} 1`)
+	assert.Contains(t, text, `proxy_request_phase_duration_seconds_bucket{pool="api",node="127.0.0.1:8080",phase="ttfb",le="0.1"} 1`)
+	assert.NotContains(t, text, `phase="dns"`)
+	assert.Contains(t, text, `proxy_connections_total{pool="api",node="127.0.0.1:8080",reused="false"} 1`)
+	assert.Contains(t, text, `proxy_pool_size{pool="api"} 2`)
+	assert.Contains(t, text, `proxy_node_weight{pool="api",node="127.0.0.1:8081"}`)
+	assert.Contains(t, text, `proxy_node_ewma_success_ratio{pool="api",node="127.0.0.1:8081"}`)
+	assert.Contains(t, text, `proxy_node_healthy{pool="api",node="127.0.0.1:8081"} 1`)
+}
+
+func TestObserver(t *testing.T) {
+	m := metrics.New()
+	p := m.Pool("direct")
+
+	s := p2c.New()
+	s.(selector.Observable).Observe(p.Observer())
+	_, _, err := s.Select(context.Background())
+	assert.True(t, errors.Is(err, selector.ErrNoAvailable))
+
+	s.Apply([]selector.Node{selector.NewNode("http", "127.0.0.1:8080", nil)})
+	n, done, err := s.Select(context.Background())
+	assert.NoError(t, err)
+	done(context.Background(), selector.DoneInfo{StatusCode: http.StatusServiceUnavailable})
+	_, done, _ = s.Select(context.Background())
+	done(context.Background(), selector.DoneInfo{Err: selector.ErrDiscarded})
+
+	text := scrape(m)
+	addr := n.Address()
+	assert.Contains(t, text, `proxy_requests_total{pool="direct",node="`+addr+`",code="503"} 1`)
+	assert.Contains(t, text, `proxy_errors_total{pool="direct",node="`+addr+`",class="server"} 1`)
+	assert.Contains(t, text, `proxy_requests_canceled_total{pool="direct",node="`+addr+`"} 1`)
+	assert.Contains(t, text, `proxy_inflight_requests{pool="direct",node="`+addr+`"} 0`)
+	assert.Contains(t, text, `proxy_no_available_total{pool="direct"} 1`)
+
+	// the series of the nodes which left the pool are dropped, of this pool only
+	m.Pool("other").Done(addr, http.StatusOK, "", time.Millisecond)
+	s.Apply([]selector.Node{selector.NewNode("http", "127.0.0.1:8081", nil)})
+	text = scrape(m)
+	assert.NotContains(t, text, `pool="direct",node="`+addr+`"`)
+	assert.Contains(t, text, `proxy_requests_total{pool="other",node="`+addr+`",code="200"} 1`)
+}
+
+func TestNilPool(t *testing.T) {
+	var p *metrics.Pool
+	p.Watch(p2c.New())
+	p.Start("127.0.0.1:8080")
+	p.Done("127.0.0.1:8080", http.StatusOK, "", time.Millisecond)
+	p.NoAvailable()
+}
@@ -0,0 +1,301 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are the default latency buckets in seconds, same as the prometheus client.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// family is a metric family in the prometheus text format.
+type family interface {
+	name() string
+	write(w io.Writer)
+}
+
+// desc describes a metric family.
+type desc struct {
+	fqName string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) name() string {
+	return d.fqName
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.fqName, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.fqName, d.typ)
+}
+
+// series is the label pair string of a sample, e.g. `pool="a",node="b"`.
+func (d *desc) series(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.fqName, len(d.labels), len(values)))
+	}
+	var b strings.Builder
+	for i, l := range d.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// value is an atomic float64.
+type value struct {
+	bits   uint64
+	labels []string
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		n := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, n) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// valueVec is a counter or gauge partitioned by labels.
+type valueVec struct {
+	desc
+
+	mu     sync.RWMutex
+	values map[string]*value
+}
+
+func newCounterVec(name, help string, labels ...string) *valueVec {
+	return &valueVec{
+		desc:   desc{fqName: name, help: help, typ: typeCounter, labels: labels},
+		values: make(map[string]*value),
+	}
+}
+
+func newGaugeVec(name, help string, labels ...string) *valueVec {
+	return &valueVec{
+		desc:   desc{fqName: name, help: help, typ: typeGauge, labels: labels},
+		values: make(map[string]*value),
+	}
+}
+
+func (vv *valueVec) with(values ...string) *value {
+	key := vv.series(values)
+
+	vv.mu.RLock()
+	v, ok := vv.values[key]
+	vv.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	vv.mu.Lock()
+	defer vv.mu.Unlock()
+	if v, ok = vv.values[key]; !ok {
+		v = &value{labels: values}
+		vv.values[key] = v
+	}
+	return v
+}
+
+// drop drops the series of which fn reports true.
+func (vv *valueVec) drop(fn func(labels []string, v float64) bool) {
+	vv.mu.Lock()
+	defer vv.mu.Unlock()
+
+	for key, v := range vv.values {
+		if fn(v.labels, v.get()) {
+			delete(vv.values, key)
+		}
+	}
+}
+
+// reset drops all samples, used by the gauges collected on scrape.
+func (vv *valueVec) reset() {
+	vv.mu.Lock()
+	defer vv.mu.Unlock()
+
+	vv.values = make(map[string]*value)
+}
+
+func (vv *valueVec) write(w io.Writer) {
+	vv.mu.RLock()
+	defer vv.mu.RUnlock()
+
+	vv.header(w)
+	for _, key := range sortedKeys(vv.values) {
+		fmt.Fprintf(w, "%s%s %s\n", vv.fqName, braces(key), formatFloat(vv.values[key].get()))
+	}
+}
+
+// histogram is a single histogram series.
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+	labels []string
+}
+
+// histogramVec is a histogram partitioned by labels.
+type histogramVec struct {
+	desc
+
+	buckets []float64
+	mu      sync.RWMutex
+	values  map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		desc:    desc{fqName: name, help: help, typ: typeHistogram, labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+}
+
+func (hv *histogramVec) observe(v float64, values ...string) {
+	key := hv.series(values)
+
+	hv.mu.RLock()
+	h, ok := hv.values[key]
+	hv.mu.RUnlock()
+	if !ok {
+		hv.mu.Lock()
+		if h, ok = hv.values[key]; !ok {
+			h = &histogram{counts: make([]uint64, len(hv.buckets)), labels: values}
+			hv.values[key] = h
+		}
+		hv.mu.Unlock()
+	}
+
+	i := sort.SearchFloat64s(hv.buckets, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// drop drops the series of which fn reports true.
+func (hv *histogramVec) drop(fn func(labels []string) bool) {
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+
+	for key, h := range hv.values {
+		if fn(h.labels) {
+			delete(hv.values, key)
+		}
+	}
+}
+
+func (hv *histogramVec) write(w io.Writer) {
+	hv.mu.RLock()
+	defer hv.mu.RUnlock()
+
+	hv.header(w)
+	for _, key := range sortedKeys(hv.values) {
+		h := hv.values[key]
+		h.mu.Lock()
+		var cumulative uint64
+		for i, upper := range hv.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.fqName, braces(join(key, `le="`+formatFloat(upper)+`"`)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hv.fqName, braces(join(key, `le="+Inf"`)), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.fqName, braces(key), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.fqName, braces(key), h.count)
+		h.mu.Unlock()
+	}
+}
+
+// writeText writes families in the prometheus text exposition format 0.0.4.
+func writeText(w io.Writer, families []family) error {
+	bw := bufio.NewWriter(w)
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name() < families[j].name()
+	})
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func braces(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "{" + s + "}"
+}
+
+func join(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
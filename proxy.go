@@ -2,14 +2,23 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/omalloc/proxy/accesslog"
+	"github.com/omalloc/proxy/limiter"
+	"github.com/omalloc/proxy/metrics"
 	"github.com/omalloc/proxy/selector"
 	"github.com/omalloc/proxy/selector/node/direct"
 	"github.com/omalloc/proxy/selector/random"
+	"github.com/omalloc/proxy/tracing"
 )
 
 type Proxy interface {
@@ -22,11 +31,42 @@ type ReverseProxy struct {
 	selector.Rebalancer
 	*direct.Builder
 
-	mu           sync.Mutex
-	dialer       *net.Dialer
-	selector     selector.Selector
-	clientMap    map[string]*http.Client
-	activateMock func(*http.Client)
+	mu             sync.Mutex
+	dialer         *net.Dialer
+	selector       selector.Selector
+	clientMap      map[string]*http.Client
+	activateMock   func(*http.Client)
+	metrics        *metrics.Pool
+	tracer         tracing.Tracer
+	balancer       string
+	nodes          atomic.Value
+	observers      selector.Observers
+	accessLog      *accesslog.Logger
+	hedge          *hedging
+	retry          *retrying
+	limiter        *limiter.Limiter
+	nodeLimiter    func() *limiter.Limiter
+	nodeLimiters   sync.Map
+	conns          *connLimiter
+	shedding       *shedding
+	rates          *rateLimiting
+	priorityHeader string
+	predictLatency LatencyPredictor
+	timeoutHeader  string
+	hashKey        func(*http.Request) string
+	sticky         *stickySession
+	stickyCookie   string
+	stickyHeader   string
+}
+
+// attempt is a single upstream attempt of a request.
+type attempt struct {
+	// retry is the number of previous attempts.
+	retry int
+	// exclude is the addresses which must not be selected.
+	exclude []string
+	// selected is called with the address of the selected node, may be nil.
+	selected func(addr string)
 }
 
 type Option func(*ReverseProxy)
@@ -41,36 +81,345 @@ func New(opts ...Option) *ReverseProxy {
 			KeepAlive: 30 * time.Second,
 		},
 		selector: random.NewBuilder().Build(), // default algorithm is random
+		tracer:   tracing.NoopTracer{},
+		rates:    newRateLimiting(),
+
+		stickyCookie: "proxy_sticky",
 	}
 
 	for _, opt := range opts {
 		opt(r)
 	}
+	if r.shedding != nil && r.shedding.capacity <= 0 && r.limiter == nil {
+		panic("proxy: WithLoadShedding without a capacity needs WithConcurrencyLimiter")
+	}
+
+	if n, ok := r.selector.(selector.Namer); ok {
+		r.balancer = n.Name()
+	}
+	r.metrics.Watch(r.selector)
 	return r
 }
 
 func (r *ReverseProxy) Do(req *http.Request) (*http.Response, error) {
-	current, done, err := r.selector.Select(req.Context())
+	start := time.Now()
+	release, err := r.admit(req)
+	if err != nil {
+		r.logAccess(req, "", 0, 0, -1, err, time.Since(start))
+		return nil, err
+	}
+
+	if err := r.rates.admit(req); err != nil {
+		release()
+		r.logAccess(req, "", 0, 0, -1, err, time.Since(start))
+		return nil, err
+	}
+
+	if _, ok := selector.FromHashKeyContext(req.Context()); !ok && r.hashKey != nil {
+		if key := r.hashKey(req); key != "" {
+			req = req.WithContext(selector.NewHashKeyContext(req.Context(), key))
+		}
+	}
+
+	req, session := r.stick(req)
+	resp, addr, attempts, err := r.retryRoundTrip(req)
+	r.reissue(resp, addr, session)
+	if err != nil || resp == nil {
+		release()
+		r.logAccess(req, addr, attempts, 0, -1, err, time.Since(start))
+		return resp, err
+	}
+	if r.shedding == nil && r.accessLog == nil {
+		return resp, nil
+	}
+	// the request is in flight until the body is read to EOF or closed
+	status := resp.StatusCode
+	return newBody(resp, func(n int64, _ http.Header, readErr error) {
+		release()
+		r.logAccess(req, addr, attempts, status, n, readErr, time.Since(start))
+	}), nil
+}
+
+// roundTrip sends req to a selected node.
+// The address of the selected node is returned, empty if none selected.
+// The attempt completes when the response body is read to EOF or closed.
+func (r *ReverseProxy) roundTrip(req *http.Request, a attempt) (*http.Response, string, error) {
+	ctx := req.Context()
+	if !tracing.SpanContextFromContext(ctx).IsValid() {
+		ctx = tracing.Extract(ctx, req.Header)
+	}
+	ctx, span := r.tracer.Start(ctx, "HTTP "+req.Method,
+		tracing.String(tracing.AttrBalancer, r.balancer),
+		tracing.Int(tracing.AttrRetry, a.retry),
+	)
+
+	token, err := r.limiter.Acquire(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.End()
+		return nil, "", err
+	}
+
+	var filters []selector.NodeFilter
+	if len(a.exclude) > 0 {
+		filters = append(filters, exclude(a.exclude))
+	}
+	if r.nodeLimiter != nil {
+		filters = append(filters, r.nodeLimitFilter)
+	}
+	current, done, err := r.selectNode(ctx, filters)
 	if err != nil {
-		return nil, selector.ErrNoAvailable
+		token.Cancel()
+		var se *selectError
+		if errors.As(err, &se) {
+			span.RecordError(se.err)
+			span.End()
+			return nil, "", se.err
+		}
+		r.metrics.NoAvailable()
+		r.observers.NotifyNoAvailable(ctx)
+		span.RecordError(selector.ErrNoAvailable)
+		span.End()
+		return nil, "", selector.ErrNoAvailable
 	}
-	defer done(req.Context(), selector.DoneInfo{
-		Err:           err,
-		BytesSent:     true,
-		BytesReceived: true,
+	r.observers.NotifySelect(ctx, current)
+
+	addr := current.Address()
+	if a.selected != nil {
+		a.selected(addr)
+	}
+	span.SetAttributes(tracing.String(tracing.AttrNodeAddress, addr))
+
+	nodeLimiter := r.nodeLimiterOf(addr)
+	nodeToken, ok := nodeLimiter.TryAcquire()
+	if !ok {
+		// all nodes are at their limit or the selector ignores the filters, wait in the queue
+		nodeToken, err = nodeLimiter.Acquire(ctx)
+	}
+	if err != nil {
+		token.Cancel()
+		r.releaseConn(addr)
+		span.RecordError(err)
+		span.End()
+		// the request is never sent, it is no sample of the node
+		done(ctx, selector.DoneInfo{Err: fmt.Errorf("%w: %w", err, selector.ErrDiscarded), Attempt: a.retry})
+		return nil, addr, err
+	}
+
+	if span.SpanContext().IsValid() || r.timeoutHeader != "" {
+		req = req.Clone(ctx)
+		if span.SpanContext().IsValid() {
+			tracing.Inject(ctx, req.Header)
+		}
+		if r.timeoutHeader != "" {
+			r.setTimeout(ctx, req.Header)
+		}
+	}
+
+	// the attempt is canceled when it completes, which also closes the connection
+	// of a body never closed
+	attemptCtx, cancel := context.WithCancel(req.Context())
+	phases := &phaseTrace{}
+	req = req.WithContext(httptrace.WithClientTrace(attemptCtx, phases.clientTrace()))
+
+	start := time.Now()
+	r.metrics.Start(addr)
+
+	resp, err := r.find(addr).Do(req)
+	timing, ttfb := phases.result(start)
+	if err == nil {
+		r.metrics.Timing(addr, timing, ttfb)
+	}
+
+	// finish must not reference resp, see newBody
+	var (
+		status int
+		md     *replyMD
+	)
+	if resp != nil {
+		status = resp.StatusCode
+		md = &replyMD{header: resp.Header}
+		span.SetAttributes(tracing.Int(tracing.AttrStatusCode, status))
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	// the loser of a hedge is canceled at the latency of the winner, it is no sample
+	if r.hedge != nil && err == nil && !errors.Is(context.Cause(attemptCtx), errHedgeCanceled) {
+		r.hedge.observe(addr, ttfb)
+	}
+
+	finish := func(n int64, trailer http.Header, readErr error) {
+		latency := time.Since(start)
+		if errors.Is(context.Cause(attemptCtx), errHedgeCanceled) {
+			// the other attempt of the hedge won, it is neither a success nor a failure
+			di := selector.DoneInfo{Err: errHedgeCanceled, BytesSent: true, Duration: latency, Attempt: a.retry}
+			r.metrics.Cancel(addr)
+			token.Cancel()
+			nodeToken.Cancel()
+			r.releaseConn(addr)
+			done(ctx, di)
+			r.observers.NotifyDone(ctx, current, di, latency)
+			span.End()
+			cancel()
+			return
+		}
+		class := ErrorClass(err, status)
+		if readErr != nil {
+			class = ErrorClass(readErr, 0)
+			span.RecordError(readErr)
+		}
+		if class != "" {
+			span.SetAttributes(tracing.String(tracing.AttrErrorClass, class))
+		}
+		di := selector.DoneInfo{
+			Err:           err,
+			BytesSent:     true,
+			BytesReceived: md != nil,
+			StatusCode:    status,
+			RequestBytes:  req.ContentLength,
+			ResponseBytes: n,
+			ReadErr:       readErr,
+			TTFB:          ttfb,
+			Timing:        timing,
+			Duration:      latency,
+			Attempt:       a.retry,
+		}
+		if md != nil {
+			md.trailer = trailer
+			di.ReplyMD = md
+		}
+		r.metrics.Done(addr, status, class, latency)
+		token.Done(latency, overloaded(class, status))
+		nodeToken.Done(latency, overloaded(class, status))
+		r.releaseConn(addr)
+		done(ctx, di)
+		r.observers.NotifyDone(ctx, current, di, latency)
+		span.End()
+		cancel()
+	}
+	if err != nil {
+		finish(0, nil, nil)
+		return resp, addr, err
+	}
+	return newBody(resp, finish), addr, nil
+}
+
+// replyMD is the metadata of an upstream response.
+type replyMD struct {
+	header  http.Header
+	trailer http.Header
+}
+
+func (md *replyMD) Get(key string) string {
+	if v := md.header.Get(key); v != "" {
+		return v
+	}
+	return md.trailer.Get(key)
+}
+
+// applyNodeLimiters drops the limiters of the removed nodes.
+func (r *ReverseProxy) applyNodeLimiters(nodes []selector.Node) {
+	addrs := make(map[string]struct{}, len(nodes))
+	for _, n := range nodes {
+		addrs[n.Address()] = struct{}{}
+	}
+	r.nodeLimiters.Range(func(addr, _ any) bool {
+		if _, ok := addrs[addr.(string)]; !ok {
+			r.nodeLimiters.Delete(addr)
+		}
+		return true
 	})
+}
 
-	return r.find(current.Address()).Do(req)
+// nodeLimiterOf returns the concurrency limiter of the node addr, nil if disabled.
+func (r *ReverseProxy) nodeLimiterOf(addr string) *limiter.Limiter {
+	if r.nodeLimiter == nil {
+		return nil
+	}
+	if l, ok := r.nodeLimiters.Load(addr); ok {
+		return l.(*limiter.Limiter)
+	}
+	l, _ := r.nodeLimiters.LoadOrStore(addr, r.nodeLimiter())
+	return l.(*limiter.Limiter)
 }
 
-func (r *ReverseProxy) find(addr string) *http.Client {
-	if client, ok := r.clientMap[addr]; ok {
-		return client
+// nodeLimitFilter drops the nodes at their concurrency limit, unless all of them are,
+// then the request waits in the queue of the picked node.
+func (r *ReverseProxy) nodeLimitFilter(_ context.Context, nodes []selector.Node) []selector.Node {
+	filtered := make([]selector.Node, 0, len(nodes))
+	for _, n := range nodes {
+		if r.nodeLimiterOf(n.Address()).Available() {
+			filtered = append(filtered, n)
+		}
+	}
+	if len(filtered) == 0 {
+		return nodes
+	}
+	return filtered
+}
+
+// releaseConn gives back the in-flight slot of the node addr.
+func (r *ReverseProxy) releaseConn(addr string) {
+	if r.conns != nil {
+		r.conns.release(addr)
+	}
+}
+
+// overloaded reports whether the result is a sign of an overloaded upstream.
+func overloaded(class string, status int) bool {
+	return class == ClassTimeout || class == ClassThrottled || status == http.StatusServiceUnavailable
+}
+
+// poolSize returns the number of applied nodes, -1 if unknown.
+func (r *ReverseProxy) poolSize() int {
+	if l, ok := r.selector.(selector.NodeLister); ok {
+		return len(l.Nodes())
+	}
+	return -1
+}
+
+// exclude returns a node filter dropping the nodes of addrs.
+func exclude(addrs []string) selector.NodeFilter {
+	return func(_ context.Context, nodes []selector.Node) []selector.Node {
+		filtered := make([]selector.Node, 0, len(nodes))
+		for _, n := range nodes {
+			if !slices.Contains(addrs, n.Address()) {
+				filtered = append(filtered, n)
+			}
+		}
+		return filtered
+	}
+}
+
+// logAccess writes the access log of req if enabled, status is zero and bytesOut is -1
+// if no response received.
+func (r *ReverseProxy) logAccess(req *http.Request, addr string, attempts, status int, bytesOut int64, err error, latency time.Duration) {
+	if r.accessLog == nil {
+		return
+	}
+	e := &accesslog.Entry{
+		Request:    req,
+		Node:       addr,
+		Attempts:   attempts,
+		Status:     status,
+		BytesIn:    req.ContentLength,
+		BytesOut:   bytesOut,
+		Latency:    latency,
+		Err:        err,
+		ErrorClass: ErrorClass(err, status),
 	}
+	r.accessLog.Log(req.Context(), e)
+}
 
+func (r *ReverseProxy) find(addr string) *http.Client {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if client, ok := r.clientMap[addr]; ok {
+		return client
+	}
+
 	tr := &http.Transport{
 		Proxy:                 http.ProxyFromEnvironment,
 		MaxConnsPerHost:       500,
@@ -104,12 +453,36 @@ func (r *ReverseProxy) find(addr string) *http.Client {
 // Apply is apply all nodes when any changes happen
 func (r *ReverseProxy) Apply(nodes []selector.Node) {
 	r.selector.Apply(nodes)
+	r.rates.apply(nodes)
+	if r.sticky != nil {
+		r.sticky.apply(nodes)
+	}
+	if r.hedge != nil {
+		r.hedge.apply(nodes)
+	}
+	r.applyNodeLimiters(nodes)
+	r.metrics.Apply(nodes)
+
+	old, _ := r.nodes.Swap(nodes).([]selector.Node)
+	r.observers.NotifyApply(old, nodes)
+}
+
+// Observe register an observer on selection, completion and pool changes of this proxy
+func (r *ReverseProxy) Observe(o selector.Observer) {
+	r.observers.Add(o)
 }
 
 // WithInitialNodes is set initial nodes
 func WithInitialNodes(nodes []selector.Node) Option {
 	return func(r *ReverseProxy) {
-		r.selector.Apply(nodes)
+		r.Apply(nodes)
+	}
+}
+
+// WithObserver is register an observer of this proxy
+func WithObserver(o selector.Observer) Option {
+	return func(r *ReverseProxy) {
+		r.Observe(o)
 	}
 }
 
@@ -127,6 +500,226 @@ func WithDialer(d *net.Dialer) Option {
 	}
 }
 
+// WithMetrics is set the metrics recorder of this proxy pool
+func WithMetrics(p *metrics.Pool) Option {
+	return func(r *ReverseProxy) {
+		r.metrics = p
+	}
+}
+
+// WithTracer is set the tracer which starts a client span per attempt
+func WithTracer(t tracing.Tracer) Option {
+	return func(r *ReverseProxy) {
+		r.tracer = t
+	}
+}
+
+// WithAccessLog is set the access logger of proxied requests
+func WithAccessLog(l *accesslog.Logger) Option {
+	return func(r *ReverseProxy) {
+		r.accessLog = l
+	}
+}
+
+// WithHedgeDelay is enable hedging of idempotent GET and HEAD requests: a backup request
+// is sent to another node if the first one hasn't responded within d
+func WithHedgeDelay(d time.Duration) Option {
+	return func(r *ReverseProxy) {
+		if r.hedge == nil {
+			r.hedge = newHedging()
+		}
+		r.hedge.delay = d
+	}
+}
+
+// WithHedgePercentile is enable hedging with the delay derived from the observed latency
+// percentile q (0, 1] of the first node, e.g. 0.95, falls back to the WithHedgeDelay delay,
+// default is 100ms, until enough latencies are observed
+func WithHedgePercentile(q float64) Option {
+	return func(r *ReverseProxy) {
+		if r.hedge == nil {
+			r.hedge = newHedging()
+		}
+		r.hedge.percentile = q
+	}
+}
+
+// WithHedgeBudget is set the budget capping hedged requests, default allows 10% extra requests
+func WithHedgeBudget(b *Budget) Option {
+	return func(r *ReverseProxy) {
+		if r.hedge == nil {
+			r.hedge = newHedging()
+		}
+		r.hedge.budget = b
+	}
+}
+
+// WithRetry is enable retries of idempotent requests failed with network errors,
+// 429, 502, 503 or 504, up to max retries per request
+func WithRetry(max int) Option {
+	return func(r *ReverseProxy) {
+		if r.retry == nil {
+			r.retry = newRetrying()
+		}
+		r.retry.max = max
+	}
+}
+
+// WithRetryBackoff is set the exponential backoff between retries, the wait before
+// the n-th retry is a random duration in [0, min(max, base*2^n)]. A Retry-After beyond
+// max isn't waited for, the response is returned instead of being retried
+func WithRetryBackoff(base, max time.Duration) Option {
+	return func(r *ReverseProxy) {
+		if r.retry == nil {
+			r.retry = newRetrying()
+		}
+		r.retry.baseBackoff = base
+		r.retry.maxBackoff = max
+	}
+}
+
+// WithRetryBudget is set the budget capping retries of this pool,
+// default allows 20% extra requests plus 10 per second
+func WithRetryBudget(b *Budget) Option {
+	return func(r *ReverseProxy) {
+		if r.retry == nil {
+			r.retry = newRetrying()
+		}
+		r.retry.budget = b
+	}
+}
+
+// WithConcurrencyLimiter is set the adaptive concurrency limiter of this pool
+func WithConcurrencyLimiter(l *limiter.Limiter) Option {
+	return func(r *ReverseProxy) {
+		r.limiter = l
+	}
+}
+
+// WithNodeConcurrencyLimiter is set the factory of the adaptive concurrency limiter per node
+func WithNodeConcurrencyLimiter(fn func() *limiter.Limiter) Option {
+	return func(r *ReverseProxy) {
+		r.nodeLimiter = fn
+	}
+}
+
+// WithMaxConns is cap the requests in flight per node, saturated nodes are skipped by
+// the balancer. The "max_conns" metadata of a node overrides n, 0 is unlimited
+func WithMaxConns(n int) Option {
+	return func(r *ReverseProxy) {
+		if r.conns == nil {
+			r.conns = newConnLimiter()
+		}
+		r.conns.max = n
+	}
+}
+
+// WithMaxConnsQueue is set the queue of requests waiting when all nodes are at capacity,
+// size is the max waiting requests and timeout is the max waiting time
+func WithMaxConnsQueue(size int, timeout time.Duration) Option {
+	return func(r *ReverseProxy) {
+		if r.conns == nil {
+			r.conns = newConnLimiter()
+		}
+		r.conns.maxQueue = size
+		r.conns.timeout = timeout
+	}
+}
+
+// WithLoadShedding is shed requests lowest priority first when the pool is saturated,
+// capacity is the requests in flight the pool can take, 0 uses the limit learned by
+// the concurrency limiter and New panics if there is none. Default thresholds are used if none given
+func WithLoadShedding(capacity int, thresholds ...ShedThreshold) Option {
+	return func(r *ReverseProxy) {
+		r.shedding = newShedding(capacity, thresholds)
+	}
+}
+
+// WithPriorityHeader is set the request header carrying the priority, default is none.
+// The header must be set by trusted clients only, as critical requests are never shed.
+// The priority in context takes precedence
+func WithPriorityHeader(name string) Option {
+	return func(r *ReverseProxy) {
+		r.priorityHeader = name
+	}
+}
+
+// WithRateLimit is limit the requests per second of this proxy, burst <= 0 is the rate.
+// The requests per second of a node is limited by its "rate" and "rate_burst" metadata
+func WithRateLimit(rate float64, burst int) Option {
+	return func(r *ReverseProxy) {
+		r.rates.global = limiter.NewBucket(rate, burst)
+	}
+}
+
+// WithKeyRateLimit is limit the requests per second of each key extracted from the request,
+// requests with an empty key are not limited
+func WithKeyRateLimit(key func(*http.Request) string, rate float64, burst int) Option {
+	return func(r *ReverseProxy) {
+		r.rates.key = key
+		r.rates.keyRate = rate
+		r.rates.keyBurst = burst
+	}
+}
+
+// WithRateLimitWait is set the max time a request waits for a token, default is 0
+// which fails the request with limiter.ErrRateLimited immediately
+func WithRateLimitWait(d time.Duration) Option {
+	return func(r *ReverseProxy) {
+		r.rates.wait = d
+	}
+}
+
+// WithDeadlineAware is skip the nodes predicted by p to respond after the request deadline,
+// nil predicts by EWMALatency. The request fails with ErrDeadlineUnreachable if no node can meet it
+func WithDeadlineAware(p LatencyPredictor) Option {
+	return func(r *ReverseProxy) {
+		if p == nil {
+			p = EWMALatency
+		}
+		r.predictLatency = p
+	}
+}
+
+// WithTimeoutHeader is set the request header carrying the remaining time of the request
+// deadline in milliseconds to upstream, e.g. "X-Request-Timeout-Ms"
+func WithTimeoutHeader(name string) Option {
+	return func(r *ReverseProxy) {
+		r.timeoutHeader = name
+	}
+}
+
+// WithHashKey is set the hash key extractor of requests for the hashing balancers,
+// e.g. hrw and jump, a key already in the request context takes precedence
+func WithHashKey(fn func(*http.Request) string) Option {
+	return func(r *ReverseProxy) {
+		r.hashKey = fn
+	}
+}
+
+// WithStickySessions is route the requests of a session to the node which served it first,
+// as long as the node is available and healthy. The node is carried in a cookie signed by secret
+func WithStickySessions(secret []byte) Option {
+	return func(r *ReverseProxy) {
+		r.sticky = newStickySession(secret)
+	}
+}
+
+// WithStickyCookie is set the cookie name of sticky sessions, default is "proxy_sticky"
+func WithStickyCookie(name string) Option {
+	return func(r *ReverseProxy) {
+		r.stickyCookie = name
+	}
+}
+
+// WithStickyHeader is set the request and response header carrying the session of sticky
+// sessions in addition to the cookie, the header takes precedence over the cookie
+func WithStickyHeader(name string) Option {
+	return func(r *ReverseProxy) {
+		r.stickyHeader = name
+	}
+}
+
 // WithActivateMock is activate httpmock
 func WithActivateMock(fn func(client *http.Client)) Option {
 	return func(r *ReverseProxy) {
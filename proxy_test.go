@@ -1,18 +1,27 @@
 package proxy
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/omalloc/proxy/accesslog"
+	"github.com/omalloc/proxy/limiter"
 	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/hrw"
+	"github.com/omalloc/proxy/tracing"
 )
 
 func TestNew(t *testing.T) {
@@ -67,6 +76,151 @@ func TestReverseProxy_Do(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
+func TestReverseProxy_Tracing(t *testing.T) {
+	var traceparent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(tracing.TraceparentHeader)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	recorder := tracing.NewRecorder()
+	p := New(
+		WithTracer(recorder),
+		WithInitialNodes([]selector.Node{&mockNode{scheme: "http", addr: ts.URL[7:]}}),
+	)
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	assert.NoError(t, err)
+
+	resp, err := p.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, req.Header.Get(tracing.TraceparentHeader))
+
+	// the span ends when the body is closed
+	assert.Empty(t, recorder.Spans())
+	_ = resp.Body.Close()
+
+	spans := recorder.Spans()
+	assert.Len(t, spans, 1)
+	sc, ok := tracing.Parse(traceparent)
+	assert.True(t, ok)
+	assert.Equal(t, spans[0].SpanContext(), sc)
+	assert.Equal(t, ts.URL[7:], spans[0].Attributes[tracing.AttrNodeAddress])
+	assert.Equal(t, "random", spans[0].Attributes[tracing.AttrBalancer])
+	assert.Equal(t, http.StatusOK, spans[0].Attributes[tracing.AttrStatusCode])
+}
+
+func TestReverseProxy_HashKey(t *testing.T) {
+	var hits [3]int
+	var servers []selector.Node
+	for i := range hits {
+		i := i
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i]++
+		}))
+		defer ts.Close()
+		servers = append(servers, selector.NewNode("http", ts.URL[7:], nil))
+	}
+
+	p := New(
+		WithSelector(hrw.New()),
+		WithInitialNodes(servers),
+		WithHashKey(func(req *http.Request) string { return req.Header.Get("X-User") }),
+	)
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+servers[0].Address(), nil)
+		req.Header.Set("X-User", "alice")
+		resp, err := p.Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	assert.ElementsMatch(t, []int{0, 0, 10}, hits[:])
+}
+
+func TestReverseProxy_NodeConcurrencyLimiter(t *testing.T) {
+	var hits [2]int64
+	release := make(chan struct{})
+	var servers []selector.Node
+	for i := range hits {
+		i := i
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&hits[i], 1)
+			<-release
+		}))
+		defer ts.Close()
+		servers = append(servers, selector.NewNode("http", ts.URL[7:], nil))
+	}
+	defer close(release)
+
+	p := New(
+		WithInitialNodes(servers),
+		WithNodeConcurrencyLimiter(func() *limiter.Limiter {
+			return limiter.New(limiter.NewAIMD(limiter.WithAIMDLimits(1, 1, 1)))
+		}),
+	)
+	do := func() error {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+servers[0].Address(), nil)
+		resp, err := p.Do(req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	// a saturated node is skipped while the other one has a free slot
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- do() }()
+		for atomic.LoadInt64(&hits[0])+atomic.LoadInt64(&hits[1]) <= int64(i) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&hits[0]))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&hits[1]))
+
+	// all nodes saturated
+	assert.True(t, errors.Is(do(), limiter.ErrLimitExceeded))
+
+	// the limiters of the removed nodes are dropped
+	p.Apply(servers[1:])
+	_, ok := p.nodeLimiters.Load(servers[0].Address())
+	assert.False(t, ok)
+	_, ok = p.nodeLimiters.Load(servers[1].Address())
+	assert.True(t, ok)
+}
+
+func TestReverseProxy_AccessLog(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 512))
+		w.(http.Flusher).Flush()
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write(make([]byte, 512))
+	}))
+	defer ts.Close()
+
+	buf := &bytes.Buffer{}
+	p := New(
+		WithInitialNodes([]selector.Node{selector.NewNode("http", ts.URL[7:], nil)}),
+		WithAccessLog(accesslog.New(buf)),
+	)
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	resp, err := p.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Zero(t, buf.Len())
+
+	// written when the body is read, with the bytes received
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	var line map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, float64(1024), line[accesslog.KeyBytesOut])
+	assert.Equal(t, float64(http.StatusOK), line[accesslog.KeyStatus])
+	assert.GreaterOrEqual(t, line[accesslog.KeyLatency], float64(20*time.Millisecond))
+}
+
 func TestReverseProxy_Apply(t *testing.T) {
 	p := New()
 	nodes := []selector.Node{
@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/omalloc/proxy/limiter"
+	"github.com/omalloc/proxy/selector"
+)
+
+const (
+	// MetadataRate is the node metadata key of the max requests per second of the node.
+	MetadataRate = "rate"
+	// MetadataRateBurst is the node metadata key of the burst of the node rate, default is the rate.
+	MetadataRateBurst = "rate_burst"
+)
+
+// minKeySweep is the number of key buckets above which the full ones are dropped.
+const minKeySweep = 1024
+
+// rateLimiting applies token bucket rate limits per proxy, per node and per request key.
+type rateLimiting struct {
+	global *limiter.Bucket
+	// wait is the max time a request waits for a token, 0 rejects it immediately.
+	wait time.Duration
+
+	key      func(*http.Request) string
+	keyRate  float64
+	keyBurst int
+
+	mu       sync.Mutex
+	keys     map[string]*limiter.Bucket
+	sweepAt  int
+	nodes    map[string]*nodeBucket
+	hasNodes bool
+}
+
+// nodeBucket is the bucket of a node with the metadata it is created from.
+type nodeBucket struct {
+	rate   string
+	burst  string
+	bucket *limiter.Bucket
+}
+
+func newRateLimiting() *rateLimiting {
+	return &rateLimiting{
+		keys:    make(map[string]*limiter.Bucket),
+		sweepAt: minKeySweep,
+		nodes:   make(map[string]*nodeBucket),
+	}
+}
+
+// admit takes the tokens of req from the proxy and the key buckets.
+func (rl *rateLimiting) admit(req *http.Request) error {
+	ctx := req.Context()
+	if err := rl.global.Wait(ctx, rl.wait); err != nil {
+		return err
+	}
+	if rl.key == nil {
+		return nil
+	}
+	return rl.keyBucket(rl.key(req)).Wait(ctx, rl.wait)
+}
+
+// keyBucket returns the bucket of key, nil if key is empty.
+func (rl *rateLimiting) keyBucket(key string) *limiter.Bucket {
+	if key == "" {
+		return nil
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if b, ok := rl.keys[key]; ok {
+		return b
+	}
+	if len(rl.keys) >= rl.sweepAt {
+		// a full bucket is the same as a new one
+		for k, b := range rl.keys {
+			if b.Full() {
+				delete(rl.keys, k)
+			}
+		}
+		rl.sweepAt = max(minKeySweep, 2*len(rl.keys))
+	}
+	b := limiter.NewBucket(rl.keyRate, rl.keyBurst)
+	rl.keys[key] = b
+	return b
+}
+
+// nodeBucket returns the bucket of n from its metadata, nil if n isn't rate limited.
+func (rl *rateLimiting) nodeBucket(n selector.Node) *limiter.Bucket {
+	md := n.Metadata()
+	rate, ok := md[MetadataRate]
+	if !ok {
+		return nil
+	}
+	burst := md[MetadataRateBurst]
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if nb, ok := rl.nodes[n.Address()]; ok && nb.rate == rate && nb.burst == burst {
+		return nb.bucket
+	}
+	v, err := strconv.ParseFloat(rate, 64)
+	if err != nil || v <= 0 {
+		return nil
+	}
+	b, _ := strconv.Atoi(burst)
+	nb := &nodeBucket{rate: rate, burst: burst, bucket: limiter.NewBucket(v, b)}
+	rl.nodes[n.Address()] = nb
+	return nb.bucket
+}
+
+// apply drops the buckets of the removed nodes.
+func (rl *rateLimiting) apply(nodes []selector.Node) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	addrs := make(map[string]struct{}, len(nodes))
+	rl.hasNodes = false
+	for _, n := range nodes {
+		addrs[n.Address()] = struct{}{}
+		if _, ok := n.Metadata()[MetadataRate]; ok {
+			rl.hasNodes = true
+		}
+	}
+	for addr := range rl.nodes {
+		if _, ok := addrs[addr]; !ok {
+			delete(rl.nodes, addr)
+		}
+	}
+}
+
+// limitsNodes reports whether any node is rate limited.
+func (rl *rateLimiting) limitsNodes() bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.hasNodes
+}
+
+// take takes a token of n, a forced token is always taken.
+func (rl *rateLimiting) take(n selector.Node, force bool) bool {
+	return rl.nodeBucket(n).Allow() || force
+}
+
+// nodeFilter drops the nodes without a token, and keeps the shortest delay
+// until a dropped node has one.
+type nodeFilter struct {
+	rl    *rateLimiting
+	delay time.Duration
+}
+
+func (f *nodeFilter) filter(_ context.Context, nodes []selector.Node) []selector.Node {
+	f.delay = 0
+	filtered := make([]selector.Node, 0, len(nodes))
+	for _, n := range nodes {
+		delay := f.rl.nodeBucket(n).Delay()
+		if delay == 0 {
+			filtered = append(filtered, n)
+			continue
+		}
+		if f.delay == 0 || delay < f.delay {
+			f.delay = delay
+		}
+	}
+	return filtered
+}
+
+// waitNode waits delay for a node token, fails with limiter.ErrRateLimited
+// if the token isn't available before deadline.
+func waitNode(ctx context.Context, delay time.Duration, deadline time.Time) error {
+	if time.Now().Add(delay).After(deadline) {
+		return limiter.ErrRateLimited
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/limiter"
+	"github.com/omalloc/proxy/selector"
+)
+
+func TestRateLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	p := New(
+		WithInitialNodes([]selector.Node{selector.NewNode("http", ts.URL[7:], nil)}),
+		WithKeyRateLimit(func(req *http.Request) string { return req.Header.Get("X-Tenant") }, 1, 1),
+	)
+	do := func(tenant string) error {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("X-Tenant", tenant)
+		resp, err := p.Do(req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	assert.NoError(t, do("a"))
+	err := do("a")
+	assert.True(t, errors.Is(err, limiter.ErrRateLimited))
+	assert.Equal(t, ClassRateLimited, ErrorClass(err, 0))
+	assert.NoError(t, do("b"))
+	assert.NoError(t, do(""))
+}
+
+func TestNodeRateLimit(t *testing.T) {
+	var hits [2]int64
+	newServer := func(i int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&hits[i], 1)
+		}))
+	}
+	ts1, ts2 := newServer(0), newServer(1)
+	defer ts1.Close()
+	defer ts2.Close()
+
+	p := New(
+		WithInitialNodes([]selector.Node{
+			selector.NewNode("http", ts1.URL[7:], selector.RawMetadata(MetadataRate, "1")),
+			selector.NewNode("http", ts2.URL[7:], selector.RawMetadata(MetadataRate, "20")),
+		}),
+		WithRateLimitWait(time.Second),
+	)
+	do := func() error {
+		req, _ := http.NewRequest(http.MethodGet, ts1.URL, nil)
+		resp, err := p.Do(req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	// 20 burst of the second node and 1 of the first, then one more waits for a token
+	for i := 0; i < 22; i++ {
+		assert.NoError(t, do())
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&hits[0]))
+	assert.Equal(t, int64(21), atomic.LoadInt64(&hits[1]))
+
+	p.rates.wait = 0
+	for i := 0; i < 20; i++ {
+		_ = do()
+	}
+	assert.True(t, errors.Is(do(), limiter.ErrRateLimited))
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultRetryRatio is the default ratio of retried requests.
+	defaultRetryRatio = 0.2
+	// defaultRetryMinPerSecond is the default retries allowed per second regardless of the ratio.
+	defaultRetryMinPerSecond = 10
+
+	defaultRetryBaseBackoff = 25 * time.Millisecond
+	defaultRetryMaxBackoff  = time.Second
+)
+
+// ErrRetryBudgetExhausted is returned when a failed request is not retried because
+// the retry budget is used up, the upstream failure is wrapped.
+var ErrRetryBudgetExhausted = errors.New("retry_budget_exhausted")
+
+// retrying retries failed requests with exponential backoff under a budget.
+type retrying struct {
+	max         int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	budget      *Budget
+}
+
+func newRetrying() *retrying {
+	return &retrying{
+		baseBackoff: defaultRetryBaseBackoff,
+		maxBackoff:  defaultRetryMaxBackoff,
+		budget:      NewBudget(defaultRetryRatio, WithMinPerSecond(defaultRetryMinPerSecond)),
+	}
+}
+
+// retryable reports whether req is idempotent and its body can be sent again.
+func (rt *retrying) retryable(req *http.Request) bool {
+	if rt.max <= 0 {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// shouldRetry reports whether the result of an attempt is worth retrying.
+func (rt *retrying) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		switch ErrorClass(err, 0) {
+		case ClassNetwork, ClassTimeout:
+			return true
+		}
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the wait before the retry-th retry, honours Retry-After on 429 and 503.
+// false is returned if Retry-After is beyond the max backoff, the request isn't retried then.
+func (rt *retrying) backoff(retry int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			return d, d <= rt.maxBackoff
+		}
+	}
+	// exponential backoff with full jitter
+	ceil := rt.baseBackoff << retry
+	if ceil <= 0 || ceil > rt.maxBackoff {
+		ceil = rt.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(ceil) + 1)), true
+}
+
+// retryAfter parses the Retry-After header in delay-seconds or HTTP-date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// retryRoundTrip sends req and retries the failures until succeeded, the max retries
+// reached or the retry budget exhausted. The attempts sent to upstream is returned.
+func (r *ReverseProxy) retryRoundTrip(req *http.Request) (*http.Response, string, int, error) {
+	if r.retry == nil || !r.retry.retryable(req) {
+		return r.send(req, attempt{})
+	}
+	r.retry.budget.Deposit()
+
+	var (
+		ctx      = req.Context()
+		tried    []string
+		attempts int
+	)
+	for retry := 0; ; retry++ {
+		if retry > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, "", attempts, err
+			}
+			req = req.WithContext(ctx)
+			req.Body = body
+		}
+
+		a := attempt{retry: attempts}
+		if size := r.poolSize(); size < 0 || size > len(tried) {
+			a.exclude = tried
+		}
+		resp, addr, n, err := r.send(req, a)
+		attempts += n
+		if addr != "" {
+			tried = append(tried, addr)
+		}
+
+		if retry >= r.retry.max || !r.retry.shouldRetry(req, resp, err) {
+			return resp, addr, attempts, err
+		}
+		wait, ok := r.retry.backoff(retry, resp)
+		if !ok {
+			// upstream asks to come back later than we would wait
+			return resp, addr, attempts, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			// no time left for another attempt
+			return resp, addr, attempts, err
+		}
+		if !r.retry.budget.Withdraw() {
+			if resp != nil {
+				discard(resp)
+				return nil, addr, attempts, fmt.Errorf("%w: upstream status %d", ErrRetryBudgetExhausted, resp.StatusCode)
+			}
+			return nil, addr, attempts, fmt.Errorf("%w: %w", ErrRetryBudgetExhausted, err)
+		}
+		if resp != nil {
+			discard(resp)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, addr, attempts, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// send sends req once, hedged if enabled.
+func (r *ReverseProxy) send(req *http.Request, a attempt) (*http.Response, string, int, error) {
+	if r.hedge != nil && r.hedge.hedgeable(req) {
+		return r.hedgeRoundTrip(req, a)
+	}
+	resp, addr, err := r.roundTrip(req, a)
+	return resp, addr, 1, err
+}
+
+// discard drains and closes the body of a response which is not returned.
+func discard(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
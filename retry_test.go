@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+)
+
+func newFlakyServer(t *testing.T, failures int64, status int) (*httptest.Server, *int64) {
+	var calls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) <= failures {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &calls
+}
+
+func TestRetry(t *testing.T) {
+	ts, calls := newFlakyServer(t, 2, http.StatusServiceUnavailable)
+
+	p := New(
+		WithInitialNodes([]selector.Node{&mockNode{scheme: "http", addr: ts.URL[7:]}}),
+		WithRetry(3),
+		WithRetryBackoff(time.Millisecond, 5*time.Millisecond),
+	)
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL, strings.NewReader("payload"))
+	resp, err := p.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(3), atomic.LoadInt64(calls))
+}
+
+func TestRetryNotIdempotent(t *testing.T) {
+	ts, calls := newFlakyServer(t, 1, http.StatusBadGateway)
+
+	p := New(
+		WithInitialNodes([]selector.Node{&mockNode{scheme: "http", addr: ts.URL[7:]}}),
+		WithRetry(3),
+	)
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("payload"))
+	resp, err := p.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, int64(1), atomic.LoadInt64(calls))
+}
+
+func TestRetryBudgetExhausted(t *testing.T) {
+	ts, calls := newFlakyServer(t, 10, http.StatusTooManyRequests)
+
+	p := New(
+		WithInitialNodes([]selector.Node{&mockNode{scheme: "http", addr: ts.URL[7:]}}),
+		WithRetry(3),
+		WithRetryBudget(NewBudget(0)),
+	)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	resp, err := p.Do(req)
+	assert.Nil(t, resp)
+	assert.True(t, errors.Is(err, ErrRetryBudgetExhausted))
+	assert.Equal(t, int64(1), atomic.LoadInt64(calls))
+}
+
+func TestBudgetMinPerSecond(t *testing.T) {
+	b := NewBudget(0, WithMinPerSecond(2))
+	assert.True(t, b.Withdraw())
+	assert.True(t, b.Withdraw())
+	assert.False(t, b.Withdraw())
+}
+
+func TestRetryAfterBeyondMaxBackoff(t *testing.T) {
+	var calls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	p := New(
+		WithInitialNodes([]selector.Node{&mockNode{scheme: "http", addr: ts.URL[7:]}}),
+		WithRetry(3),
+		WithRetryBackoff(time.Millisecond, 5*time.Millisecond),
+	)
+
+	start := time.Now()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	resp, err := p.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "3600", resp.Header.Get("Retry-After"))
+	_ = resp.Body.Close()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&calls))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRetryAfter(t *testing.T) {
+	d, ok := retryAfter("3")
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+
+	d, ok = retryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.True(t, ok)
+	assert.InDelta(t, float64(time.Hour), float64(d), float64(2*time.Second))
+
+	_, ok = retryAfter("soon")
+	assert.False(t, ok)
+}
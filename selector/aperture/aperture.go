@@ -0,0 +1,302 @@
+package aperture
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/node/ewma"
+)
+
+const (
+	// Name is aperture balancer name
+	Name = "aperture"
+
+	defaultMinAperture = 5
+	defaultLowLoad     = 0.5
+	defaultHighLoad    = 2
+)
+
+var (
+	_ selector.Balancer           = (*Balancer)(nil)
+	_ selector.BalancerRebalancer = (*Balancer)(nil)
+)
+
+func init() {
+	selector.Register(Name, NewBuilder())
+}
+
+// Option is aperture builder option.
+type Option func(o *options)
+
+// options is aperture builder options
+type options struct {
+	index       int
+	peers       int
+	minAperture int
+	lowLoad     float64
+	highLoad    float64
+	node        selector.WeightedNodeBuilder
+	source      rand.Source
+}
+
+// WithCoordinate set the index of this client among peers clients of the same pool,
+// the clients are spread evenly on the ring of nodes. Default is a random offset.
+func WithCoordinate(index, peers int) Option {
+	return func(o *options) {
+		o.index = index
+		o.peers = peers
+	}
+}
+
+// WithMinAperture set the min number of nodes in the window, default is 5.
+func WithMinAperture(n int) Option {
+	return func(o *options) {
+		o.minAperture = n
+	}
+}
+
+// WithLoadBand set the requests in flight per node in the window below which the window
+// shrinks and above which it grows, default is 0.5 and 2.
+func WithLoadBand(low, high float64) Option {
+	return func(o *options) {
+		o.lowLoad = low
+		o.highLoad = high
+	}
+}
+
+// WithNodeBuilder set the weighted node builder, default is the ewma node builder.
+func WithNodeBuilder(b selector.WeightedNodeBuilder) Option {
+	return func(o *options) {
+		o.node = b
+	}
+}
+
+// WithSource set the random source, default is seeded by the current time.
+func WithSource(src rand.Source) Option {
+	return func(o *options) {
+		o.source = src
+	}
+}
+
+// WithSeed set the seed of the random source, for deterministic picks in tests.
+func WithSeed(seed int64) Option {
+	return WithSource(rand.NewSource(seed))
+}
+
+// New creates an aperture selector.
+func New(opts ...Option) selector.Selector {
+	return NewBuilder(opts...).Build()
+}
+
+// Balancer picks from a window of the nodes sorted by address on a ring, by two random choices.
+// The window of a client starts at its coordinate, so the clients of a pool spread evenly
+// with each connecting to a few nodes only. The window grows or shrinks to keep the requests
+// in flight per node in the load band.
+type Balancer struct {
+	mu       sync.Mutex
+	r        *rand.Rand
+	offset   float64
+	peers    int
+	min      int
+	low      float64
+	high     float64
+	aperture int
+	inflight int64
+	ring     ring
+	buf      []selector.WeightedNode
+}
+
+// Name is balancer name
+func (b *Balancer) Name() string {
+	return Name
+}
+
+// Aperture is the current number of nodes in the window.
+func (b *Balancer) Aperture() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.aperture
+}
+
+// ring is the applied nodes sorted by address.
+type ring struct {
+	nodes []selector.WeightedNode
+	index map[string]int // addr -> position
+	// avail marks the candidates of a pick, it is reset after each pick.
+	avail []bool
+}
+
+func newRing(nodes []selector.WeightedNode) ring {
+	sorted := make([]selector.WeightedNode, len(nodes))
+	copy(sorted, nodes)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Address() < sorted[j].Address() })
+	index := make(map[string]int, len(sorted))
+	for i, n := range sorted {
+		index[n.Address()] = i
+	}
+	return ring{nodes: sorted, index: index, avail: make([]bool, len(sorted))}
+}
+
+// mark marks the candidates on the ring, false if any of them isn't on it.
+func (r *ring) mark(nodes []selector.WeightedNode) bool {
+	for i, n := range nodes {
+		pos, ok := r.index[n.Address()]
+		if !ok || r.nodes[pos] != n {
+			r.unmark(nodes[:i])
+			return false
+		}
+		r.avail[pos] = true
+	}
+	return true
+}
+
+func (r *ring) unmark(nodes []selector.WeightedNode) {
+	for _, n := range nodes {
+		if pos, ok := r.index[n.Address()]; ok {
+			r.avail[pos] = false
+		}
+	}
+}
+
+// window appends to buf the first size candidates from start, skipping the others.
+func (r *ring) window(buf []selector.WeightedNode, start, size int) []selector.WeightedNode {
+	n := len(r.nodes)
+	for i := 0; i < n && len(buf) < size; i++ {
+		if pos := (start + i) % n; r.avail[pos] {
+			buf = append(buf, r.nodes[pos])
+		}
+	}
+	return buf
+}
+
+// Apply sorts the applied nodes on the ring.
+func (b *Balancer) Apply(nodes []selector.WeightedNode) {
+	r := newRing(nodes)
+	b.mu.Lock()
+	b.ring = r
+	b.mu.Unlock()
+}
+
+// least is the min aperture of n nodes, the clients together must cover the whole ring.
+func (b *Balancer) least(n int) int {
+	least := b.min
+	if b.peers > 0 {
+		least = max(least, int(math.Ceil(float64(n)/float64(b.peers))))
+	}
+	return min(least, n)
+}
+
+// adjust grows or shrinks the window of n nodes by the load, it must be called with b.mu held.
+func (b *Balancer) adjust(n int) {
+	least := b.least(n)
+	b.aperture = min(max(b.aperture, least), n)
+
+	load := float64(b.inflight) / float64(b.aperture)
+	switch {
+	case load > b.high && b.aperture < n:
+		b.aperture++
+	case load < b.low && b.aperture > least:
+		b.aperture--
+	}
+}
+
+// Pick pick a node.
+func (b *Balancer) Pick(_ context.Context, nodes []selector.WeightedNode) (selector.WeightedNode, selector.DoneFunc, error) {
+	if len(nodes) == 0 {
+		return nil, nil, selector.ErrNoAvailable
+	}
+
+	b.mu.Lock()
+	r := &b.ring
+	if !r.mark(nodes) {
+		// the nodes aren't applied, e.g. the balancer is used without a selector
+		tmp := newRing(nodes)
+		r = &tmp
+		r.mark(nodes)
+	}
+	b.adjust(len(nodes))
+	// the window starts at the offset of the whole ring and wraps around,
+	// the nodes filtered out of the pick are skipped so that the window doesn't shift
+	window := r.window(b.buf[:0], int(b.offset*float64(len(r.nodes))), b.aperture)
+	r.unmark(nodes)
+	size := len(window)
+	i := b.r.Intn(size)
+	picked := window[i]
+	if size > 1 {
+		j := b.r.Intn(size - 1)
+		if j >= i {
+			j++
+		}
+		if other := window[j]; other.Weight() > picked.Weight() {
+			picked = other
+		}
+	}
+	clear(window)
+	b.buf = window[:0]
+	b.inflight++
+	b.mu.Unlock()
+
+	done := picked.Pick()
+	return picked, func(ctx context.Context, di selector.DoneInfo) {
+		b.mu.Lock()
+		b.inflight--
+		b.mu.Unlock()
+		done(ctx, di)
+	}, nil
+}
+
+// NewBuilder returns a selector builder with aperture balancer
+func NewBuilder(opts ...Option) selector.Builder {
+	var option options
+	for _, opt := range opts {
+		opt(&option)
+	}
+	node := option.node
+	if node == nil {
+		node = &ewma.Builder{}
+	}
+	return &selector.DefaultBuilder{
+		Balancer: &Builder{opts: option},
+		Node:     node,
+	}
+}
+
+// Builder is aperture builder
+type Builder struct {
+	opts options
+}
+
+// Build creates Balancer
+func (bb *Builder) Build() selector.Balancer {
+	o := bb.opts
+	src := o.source
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	b := &Balancer{
+		r:     rand.New(src),
+		peers: o.peers,
+		min:   o.minAperture,
+		low:   o.lowLoad,
+		high:  o.highLoad,
+	}
+	if b.min <= 0 {
+		b.min = defaultMinAperture
+	}
+	if b.high <= 0 {
+		b.low, b.high = defaultLowLoad, defaultHighLoad
+	}
+	if o.peers > 0 {
+		b.offset = float64(o.index%o.peers) / float64(o.peers)
+	} else {
+		b.offset = b.r.Float64()
+	}
+	b.aperture = b.min
+	return b
+}
@@ -0,0 +1,84 @@
+package aperture_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/aperture"
+	"github.com/omalloc/proxy/selector/node/direct"
+)
+
+func TestWindow(t *testing.T) {
+	var (
+		addrs []string
+		nodes []selector.Node
+	)
+	for i := 0; i < 100; i++ {
+		addrs = append(addrs, fmt.Sprintf("10.0.0.%03d:80", i))
+		nodes = append(nodes, selector.NewNode("http", addrs[i], nil))
+	}
+	// the 4th of 10 clients covers the 4th tenth of the ring
+	s := aperture.New(
+		aperture.WithCoordinate(3, 10),
+		aperture.WithNodeBuilder(&direct.Builder{}),
+		aperture.WithLoadBand(0, 100),
+	)
+	s.Apply(nodes)
+
+	// the filtered nodes are skipped without shifting the window
+	skip := selector.WithNodeFilter(func(_ context.Context, nodes []selector.Node) []selector.Node {
+		var kept []selector.Node
+		for _, n := range nodes {
+			if n.Address() != addrs[30] && n.Address() != addrs[31] {
+				kept = append(kept, n)
+			}
+		}
+		return kept
+	})
+	for _, tt := range []struct {
+		name     string
+		opts     []selector.SelectOption
+		from, to string
+	}{
+		{"all", nil, addrs[30], addrs[40]},
+		{"filtered", []selector.SelectOption{skip}, addrs[32], addrs[42]},
+	} {
+		for i := 0; i < 100; i++ {
+			n, done, err := s.Select(context.Background(), tt.opts...)
+			assert.NoError(t, err)
+			done(context.Background(), selector.DoneInfo{})
+			assert.GreaterOrEqual(t, n.Address(), tt.from, tt.name)
+			assert.Less(t, n.Address(), tt.to, tt.name)
+		}
+	}
+}
+
+func TestAperture(t *testing.T) {
+	// the window grows under load
+	b := (&aperture.Builder{}).Build().(*aperture.Balancer)
+	var nodes []selector.WeightedNode
+	for i := 0; i < 100; i++ {
+		nodes = append(nodes, (&direct.Builder{}).Build(selector.NewNode("http", fmt.Sprintf("10.0.0.%03d:80", i), nil)))
+	}
+	assert.Equal(t, 5, b.Aperture())
+	var dones []selector.DoneFunc
+	for i := 0; i < 100; i++ {
+		_, done, err := b.Pick(context.Background(), nodes)
+		assert.NoError(t, err)
+		dones = append(dones, done)
+	}
+	grown := b.Aperture()
+	assert.Greater(t, grown, 5)
+	for _, done := range dones {
+		done(context.Background(), selector.DoneInfo{})
+	}
+	for i := 0; i < 100; i++ {
+		_, done, _ := b.Pick(context.Background(), nodes)
+		done(context.Background(), selector.DoneInfo{})
+	}
+	assert.Less(t, b.Aperture(), grown)
+}
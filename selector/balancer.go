@@ -10,6 +10,12 @@ type Balancer interface {
 	Pick(ctx context.Context, nodes []WeightedNode) (selected WeightedNode, done DoneFunc, err error)
 }
 
+// BalancerRebalancer is implemented by the balancers which keep state of the applied nodes,
+// e.g. the nodes sorted on a ring. Apply is called before the nodes are picked from.
+type BalancerRebalancer interface {
+	Apply(nodes []WeightedNode)
+}
+
 // BalancerBuilder build balancer
 type BalancerBuilder interface {
 	Build() Balancer
@@ -65,6 +71,42 @@ type DoneInfo struct {
 	BytesSent bool
 	// BytesReceived indicates if any byte has been received from the server.
 	BytesReceived bool
+	// StatusCode is the status code of the response, zero if no response received.
+	StatusCode int
+	// ReplyMD is the response header and trailer, nil if no response received.
+	ReplyMD ReplyMD
+	// RequestBytes is the length of the request body, -1 if unknown.
+	RequestBytes int64
+	// ResponseBytes is the number of response body bytes read.
+	ResponseBytes int64
+	// ReadErr is the error reading the response body, nil if it is read to EOF or closed.
+	ReadErr error
+	// TTFB is the time from the request sent until the first response byte received.
+	TTFB time.Duration
+	// Timing is the connection phases of the request.
+	Timing Timing
+	// Duration is the time from the request sent until the response body is read to EOF
+	// or closed, or the request failed.
+	Duration time.Duration
+	// Attempt is the number of previous attempts of the request, zero on the first one.
+	Attempt int
+}
+
+// Timing is the duration of the connection phases of a request, a phase which didn't
+// happen is zero, e.g. all of them on a reused connection.
+type Timing struct {
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	// ConnReused reports whether the request is sent on a reused connection.
+	ConnReused bool
+}
+
+// ReplyMD is the metadata of a response.
+type ReplyMD interface {
+	// Get returns the first value of the header key, then the trailer key.
+	// Trailers are available after the body is read to EOF.
+	Get(key string) string
 }
 
 // DoneFunc is callback function when RPC invoke done.
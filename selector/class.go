@@ -0,0 +1,47 @@
+package selector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// error classes of a failed upstream request.
+const (
+	ClassNoAvailable = "no_available"
+	ClassCanceled    = "canceled"
+	ClassTimeout     = "timeout"
+	ClassNetwork     = "network"
+	ClassUnknown     = "unknown"
+	ClassThrottled   = "throttled"
+	ClassServer      = "server"
+)
+
+// ErrorClass returns the class of an upstream request result, empty on success.
+func ErrorClass(err error, status int) string {
+	if err != nil {
+		var netErr net.Error
+		switch {
+		case errors.Is(err, ErrNoAvailable):
+			return ClassNoAvailable
+		case errors.Is(err, context.Canceled):
+			return ClassCanceled
+		case errors.Is(err, context.DeadlineExceeded):
+			return ClassTimeout
+		case errors.As(err, &netErr):
+			if netErr.Timeout() {
+				return ClassTimeout
+			}
+			return ClassNetwork
+		}
+		return ClassUnknown
+	}
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ClassThrottled
+	case status >= http.StatusInternalServerError:
+		return ClassServer
+	}
+	return ""
+}
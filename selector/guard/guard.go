@@ -0,0 +1,134 @@
+package guard
+
+import (
+	"sync"
+
+	"github.com/omalloc/proxy/selector"
+)
+
+const (
+	// defaultMaxShrink is the default percentage the pool may shrink by in one update.
+	defaultMaxShrink = 50
+)
+
+var _ selector.Rebalancer = (*Guard)(nil)
+
+// Reason is the reason why an update is rejected.
+type Reason string
+
+const (
+	// ReasonEmpty the update contains no node.
+	ReasonEmpty Reason = "empty"
+	// ReasonShrink the update shrinks the pool more than allowed.
+	ReasonShrink Reason = "shrink"
+)
+
+// Event is emitted when an update is rejected.
+type Event struct {
+	Reason Reason
+	// Current is the size of the last-known-good pool.
+	Current int
+	// Proposed is the size of the rejected update.
+	Proposed int
+}
+
+// Option is guard option.
+type Option func(o *options)
+
+// options is guard options
+type options struct {
+	maxShrink int
+	onReject  func(Event)
+}
+
+// WithMaxShrink set the max percentage [0, 100] the pool may shrink by in one update,
+// 100 disables the check, default is 50.
+func WithMaxShrink(percent int) Option {
+	return func(o *options) {
+		o.maxShrink = percent
+	}
+}
+
+// WithOnReject set the callback invoked when an update is rejected.
+func WithOnReject(fn func(Event)) Option {
+	return func(o *options) {
+		o.onReject = fn
+	}
+}
+
+// Guard protects target from empty or sharply shrunk updates,
+// a rejected update keeps the last-known-good nodes applied.
+type Guard struct {
+	mu     sync.Mutex
+	target selector.Rebalancer
+	opts   options
+	nodes  []selector.Node
+}
+
+// New create a guard in front of target.
+func New(target selector.Rebalancer, opts ...Option) *Guard {
+	g := &Guard{
+		target: target,
+		opts: options{
+			maxShrink: defaultMaxShrink,
+		},
+	}
+	for _, opt := range opts {
+		opt(&g.opts)
+	}
+	return g
+}
+
+// Apply is apply all nodes when any changes happen,
+// the nodes are dropped if the update is unsafe.
+func (g *Guard) Apply(nodes []selector.Node) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if reason, ok := g.check(nodes); !ok {
+		if g.opts.onReject != nil {
+			g.opts.onReject(Event{
+				Reason:   reason,
+				Current:  len(g.nodes),
+				Proposed: len(nodes),
+			})
+		}
+		return
+	}
+	g.apply(nodes)
+}
+
+// Force apply nodes without any check, e.g. the pool is scaled down on purpose.
+func (g *Guard) Force(nodes []selector.Node) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.apply(nodes)
+}
+
+// Nodes returns the last-known-good nodes.
+func (g *Guard) Nodes() []selector.Node {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.nodes
+}
+
+func (g *Guard) check(nodes []selector.Node) (Reason, bool) {
+	if len(nodes) == 0 {
+		return ReasonEmpty, false
+	}
+	current := len(g.nodes)
+	if current == 0 || len(nodes) >= current {
+		return "", true
+	}
+	if (current-len(nodes))*100 > current*g.opts.maxShrink {
+		return ReasonShrink, false
+	}
+	return "", true
+}
+
+func (g *Guard) apply(nodes []selector.Node) {
+	g.nodes = nodes
+	g.target.Apply(nodes)
+}
@@ -0,0 +1,53 @@
+package guard_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/guard"
+	"github.com/omalloc/proxy/selector/random"
+)
+
+func TestGuard(t *testing.T) {
+	var events []guard.Event
+	s := random.New()
+	g := guard.New(s, guard.WithOnReject(func(e guard.Event) {
+		events = append(events, e)
+	}))
+
+	for _, tt := range []struct {
+		name  string
+		force bool
+		n     int
+		want  int
+		event *guard.Event
+	}{
+		{"empty first", false, 0, 0, &guard.Event{Reason: guard.ReasonEmpty}},
+		{"first", false, 10, 10, nil},
+		{"empty", false, 0, 10, &guard.Event{Reason: guard.ReasonEmpty, Current: 10}},
+		{"shrink by half", false, 5, 5, nil},
+		{"shrink too much", false, 2, 5, &guard.Event{Reason: guard.ReasonShrink, Current: 5, Proposed: 2}},
+		{"forced", true, 2, 2, nil},
+	} {
+		events = events[:0]
+		nodes := make([]selector.Node, 0, tt.n)
+		for i := 0; i < tt.n; i++ {
+			nodes = append(nodes, selector.NewNode("http", fmt.Sprintf("127.0.0.1:80%02d", i), nil))
+		}
+		if tt.force {
+			g.Force(nodes)
+		} else {
+			g.Apply(nodes)
+		}
+		assert.Len(t, g.Nodes(), tt.want, tt.name)
+		assert.Len(t, s.(selector.NodeLister).Nodes(), tt.want, tt.name)
+		if tt.event != nil {
+			assert.Equal(t, []guard.Event{*tt.event}, events, tt.name)
+		} else {
+			assert.Empty(t, events, tt.name)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package hrw
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/internal/rnd"
+	"github.com/omalloc/proxy/selector/node/direct"
+)
+
+const (
+	// Name is hrw(highest random weight) balancer name
+	Name = "hrw"
+)
+
+var _ selector.Balancer = (*Balancer)(nil)
+
+func init() {
+	selector.Register(Name, NewBuilder())
+}
+
+// Option is hrw builder option.
+type Option func(o *options)
+
+// options is hrw builder options
+type options struct {
+	node   selector.WeightedNodeBuilder
+	source rand.Source
+}
+
+// WithNodeBuilder set the weighted node builder, default is the direct node builder.
+func WithNodeBuilder(b selector.WeightedNodeBuilder) Option {
+	return func(o *options) {
+		o.node = b
+	}
+}
+
+// WithSource set the random source of the requests without a hash key,
+// default is seeded by the current time.
+func WithSource(src rand.Source) Option {
+	return func(o *options) {
+		o.source = src
+	}
+}
+
+// WithSeed set the seed of the random source, for deterministic picks in tests.
+func WithSeed(seed int64) Option {
+	return WithSource(rand.NewSource(seed))
+}
+
+// New creates a hrw selector.
+func New(opts ...Option) selector.Selector {
+	return NewBuilder(opts...).Build()
+}
+
+// Balancer is a weighted rendezvous hashing balancer: the node of the highest score of the
+// hash key of the request is picked, the score is scaled by the node weight. Adding or removing
+// a node only moves the keys of it. Requests without a hash key are picked randomly.
+type Balancer struct {
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// Name is balancer name
+func (p *Balancer) Name() string {
+	return Name
+}
+
+// Pick is pick a weighted node.
+func (p *Balancer) Pick(ctx context.Context, nodes []selector.WeightedNode) (selector.WeightedNode, selector.DoneFunc, error) {
+	if len(nodes) == 0 {
+		return nil, nil, selector.ErrNoAvailable
+	}
+
+	key, ok := selector.FromHashKeyContext(ctx)
+	if !ok {
+		p.mu.Lock()
+		selected := nodes[p.random.Intn(len(nodes))]
+		p.mu.Unlock()
+		return selected, selected.Pick(), nil
+	}
+
+	var (
+		selected selector.WeightedNode
+		best     = math.Inf(-1)
+	)
+	for _, n := range nodes {
+		if s := Score(key, n.Address(), n.Weight()); selected == nil || s > best {
+			selected, best = n, s
+		}
+	}
+	return selected, selected.Pick(), nil
+}
+
+// Score is the weighted rendezvous score of key on the node addr, -w/ln(h) with the hash h
+// in (0, 1), so that a node is picked with the probability of its share of the weights.
+func Score(key, addr string, weight float64) float64 {
+	if weight <= 0 {
+		return math.Inf(-1)
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(addr))
+	// the top 53 bits as a float in (0, 1)
+	u := (float64(rnd.Mix(h.Sum64())>>11) + 0.5) / (1 << 53)
+	return -weight / math.Log(u)
+}
+
+// NewBuilder returns a selector builder with hrw balancer
+func NewBuilder(opts ...Option) selector.Builder {
+	var option options
+	for _, opt := range opts {
+		opt(&option)
+	}
+	node := option.node
+	if node == nil {
+		node = &direct.Builder{}
+	}
+	return &selector.DefaultBuilder{
+		Balancer: &Builder{source: option.source},
+		Node:     node,
+	}
+}
+
+// Builder is hrw builder
+type Builder struct {
+	source rand.Source
+}
+
+// Build creates Balancer
+func (b *Builder) Build() selector.Balancer {
+	src := b.source
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	return &Balancer{random: rand.New(src)}
+}
@@ -0,0 +1,53 @@
+package hrw_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/hrw"
+)
+
+func TestHRW(t *testing.T) {
+	pick := func(s selector.Selector, key string) string {
+		n, done, err := s.Select(selector.NewHashKeyContext(context.Background(), key))
+		assert.NoError(t, err)
+		done(context.Background(), selector.DoneInfo{})
+		return n.Address()
+	}
+
+	var nodes []selector.Node
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, selector.NewNode("http", fmt.Sprintf("10.0.0.%d:80", i), nil))
+	}
+	s1, s2 := hrw.New(), hrw.New()
+	s1.Apply(nodes)
+	s2.Apply(nodes[:9])
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		// affinity
+		assert.Equal(t, pick(s1, key), pick(s1, key))
+		// only the keys of the removed node move
+		if a := pick(s1, key); a != pick(s2, key) {
+			assert.Equal(t, nodes[9].Address(), a)
+			moved++
+		}
+	}
+	assert.InDelta(t, 100, moved, 40)
+
+	// the keys are spread by the weights
+	s := hrw.New()
+	s.Apply([]selector.Node{
+		selector.NewNode("http", "10.0.0.1:80", selector.RawMetadata("weight", "100")),
+		selector.NewNode("http", "10.0.0.2:80", selector.RawMetadata("weight", "300")),
+	})
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		counts[pick(s, fmt.Sprintf("user-%d", i))]++
+	}
+	assert.InDelta(t, 1000, counts["10.0.0.1:80"], 150)
+}
@@ -0,0 +1,30 @@
+// Package rnd is the randomness shared by the balancers.
+package rnd
+
+import "math/rand"
+
+// Sample returns k distinct indexes of [0, n) by Floyd's sampling without replacement.
+func Sample(r *rand.Rand, n, k int) []int {
+	chosen := make([]int, 0, k)
+	for j := n - k; j < n; j++ {
+		t := r.Intn(j + 1)
+		for _, c := range chosen {
+			if c == t {
+				t = j
+				break
+			}
+		}
+		chosen = append(chosen, t)
+	}
+	return chosen
+}
+
+// Mix is the splitmix64 finalizer, spreading the close fnv hashes of similar inputs.
+func Mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
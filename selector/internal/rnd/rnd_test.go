@@ -0,0 +1,22 @@
+package rnd
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSample(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, tt := range []struct{ n, k int }{{1, 1}, {5, 2}, {5, 5}, {100, 10}} {
+		chosen := Sample(r, tt.n, tt.k)
+		assert.Len(t, chosen, tt.k)
+		seen := make(map[int]bool)
+		for _, c := range chosen {
+			assert.False(t, seen[c], "%+v", tt)
+			assert.True(t, c >= 0 && c < tt.n, "%+v", tt)
+			seen[c] = true
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package jump
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/node/direct"
+)
+
+const (
+	// Name is jump consistent hash balancer name
+	Name = "jump"
+
+	// MetadataShard is the node metadata key of the shard number the nodes are ordered by,
+	// the nodes are ordered by address if any of them has none.
+	MetadataShard = "shard"
+)
+
+var _ selector.Balancer = (*Balancer)(nil)
+
+func init() {
+	selector.Register(Name, NewBuilder())
+}
+
+// Option is jump builder option.
+type Option func(o *options)
+
+// options is jump builder options
+type options struct {
+	node   selector.WeightedNodeBuilder
+	source rand.Source
+}
+
+// WithNodeBuilder set the weighted node builder, default is the direct node builder.
+func WithNodeBuilder(b selector.WeightedNodeBuilder) Option {
+	return func(o *options) {
+		o.node = b
+	}
+}
+
+// WithSource set the random source of the requests without a hash key,
+// default is seeded by the current time.
+func WithSource(src rand.Source) Option {
+	return func(o *options) {
+		o.source = src
+	}
+}
+
+// WithSeed set the seed of the random source, for deterministic picks in tests.
+func WithSeed(seed int64) Option {
+	return WithSource(rand.NewSource(seed))
+}
+
+// New creates a jump selector.
+func New(opts ...Option) selector.Selector {
+	return NewBuilder(opts...).Build()
+}
+
+// Balancer is a jump consistent hash balancer for numbered shards: the hash key of the request
+// is mapped to a bucket of the nodes ordered by shard. Growing the shards only moves the keys
+// to the new ones. Requests without a hash key are picked randomly.
+type Balancer struct {
+	mu     sync.Mutex
+	random *rand.Rand
+	// cached order of the applied nodes
+	nodes   []selector.WeightedNode
+	ordered []selector.WeightedNode
+}
+
+// Name is balancer name
+func (p *Balancer) Name() string {
+	return Name
+}
+
+// Pick is pick a weighted node.
+func (p *Balancer) Pick(ctx context.Context, nodes []selector.WeightedNode) (selector.WeightedNode, selector.DoneFunc, error) {
+	if len(nodes) == 0 {
+		return nil, nil, selector.ErrNoAvailable
+	}
+
+	key, ok := selector.FromHashKeyContext(ctx)
+
+	p.mu.Lock()
+	var selected selector.WeightedNode
+	if ok {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		selected = p.order(nodes)[Hash(h.Sum64(), len(nodes))]
+	} else {
+		selected = nodes[p.random.Intn(len(nodes))]
+	}
+	p.mu.Unlock()
+
+	return selected, selected.Pick(), nil
+}
+
+// order returns the nodes ordered by shard, it must be called with p.mu held.
+// The applied nodes are passed as the same slice until the next apply,
+// filtered nodes are a new slice on every pick.
+func (p *Balancer) order(nodes []selector.WeightedNode) []selector.WeightedNode {
+	if len(p.nodes) == len(nodes) && &p.nodes[0] == &nodes[0] {
+		return p.ordered
+	}
+
+	ordered := make([]selector.WeightedNode, len(nodes))
+	copy(ordered, nodes)
+	shards := make(map[selector.Node]int, len(nodes))
+	for _, n := range nodes {
+		shard, err := strconv.Atoi(n.Metadata()[MetadataShard])
+		if err != nil {
+			shards = nil
+			break
+		}
+		shards[n] = shard
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if shards != nil && shards[ordered[i]] != shards[ordered[j]] {
+			return shards[ordered[i]] < shards[ordered[j]]
+		}
+		return ordered[i].Address() < ordered[j].Address()
+	})
+	p.nodes, p.ordered = nodes, ordered
+	return ordered
+}
+
+// Hash is the jump consistent hash of key in [0, buckets), by Lamping and Veach.
+func Hash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// NewBuilder returns a selector builder with jump balancer
+func NewBuilder(opts ...Option) selector.Builder {
+	var option options
+	for _, opt := range opts {
+		opt(&option)
+	}
+	node := option.node
+	if node == nil {
+		node = &direct.Builder{}
+	}
+	return &selector.DefaultBuilder{
+		Balancer: &Builder{source: option.source},
+		Node:     node,
+	}
+}
+
+// Builder is jump builder
+type Builder struct {
+	source rand.Source
+}
+
+// Build creates Balancer
+func (b *Builder) Build() selector.Balancer {
+	src := b.source
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	return &Balancer{random: rand.New(src)}
+}
@@ -0,0 +1,56 @@
+package jump_test
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/jump"
+)
+
+func TestJump(t *testing.T) {
+	pick := func(s selector.Selector, key string) string {
+		n, done, err := s.Select(selector.NewHashKeyContext(context.Background(), key))
+		assert.NoError(t, err)
+		done(context.Background(), selector.DoneInfo{})
+		return n.Address()
+	}
+
+	var nodes []selector.Node
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, selector.NewNode("http", fmt.Sprintf("10.0.0.%d:80", i), nil))
+	}
+	s1, s2 := jump.New(), jump.New()
+	s1.Apply(nodes)
+	s2.Apply(nodes[:9])
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		// affinity
+		assert.Equal(t, pick(s1, key), pick(s1, key))
+		// only the keys of the removed node move
+		if a := pick(s1, key); a != pick(s2, key) {
+			assert.Equal(t, nodes[9].Address(), a)
+			moved++
+		}
+	}
+	assert.InDelta(t, 100, moved, 40)
+
+	// the nodes are ordered by shard
+	s := jump.New()
+	s.Apply([]selector.Node{
+		selector.NewNode("http", "10.0.0.9:80", selector.RawMetadata(jump.MetadataShard, "0")),
+		selector.NewNode("http", "10.0.0.1:80", selector.RawMetadata(jump.MetadataShard, "1")),
+	})
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		want := []string{"10.0.0.9:80", "10.0.0.1:80"}[jump.Hash(h.Sum64(), 2)]
+		assert.Equal(t, want, pick(s, key))
+	}
+}
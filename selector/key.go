@@ -0,0 +1,17 @@
+package selector
+
+import "context"
+
+type hashKey struct{}
+
+// NewHashKeyContext creates a new context with the hash key of the request attached,
+// hashing balancers pick the node of the key.
+func NewHashKeyContext(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// FromHashKeyContext returns the hash key in ctx if it exists.
+func FromHashKeyContext(ctx context.Context) (key string, ok bool) {
+	key, ok = ctx.Value(hashKey{}).(string)
+	return
+}
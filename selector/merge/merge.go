@@ -0,0 +1,165 @@
+package merge
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/omalloc/proxy/selector"
+)
+
+var _ selector.Rebalancer = (*source)(nil)
+
+// Policy resolves the metadata of a node reported by more than one source.
+type Policy int
+
+const (
+	// PreferFirst keeps the node reported by the source with the highest precedence
+	// and ignores the others.
+	PreferFirst Policy = iota
+	// MergeMetadata merges the metadata of every report, for the same key
+	// the source with the highest precedence wins.
+	MergeMetadata
+)
+
+// Option is merger option.
+type Option func(o *options)
+
+// options is merger options
+type options struct {
+	policy Policy
+}
+
+// WithPolicy set the conflict policy, default is PreferFirst.
+func WithPolicy(p Policy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// Merger merges the node sets of several discovery sources into a single pool.
+//
+// Each source is a selector.Rebalancer, sources registered earlier take precedence
+// over sources registered later. Nodes are deduplicated by address and the merged
+// set is applied to the target whenever any source changes.
+type Merger struct {
+	mu      sync.Mutex
+	target  selector.Rebalancer
+	opts    options
+	sources []*source
+}
+
+// New create a merger which applies the merged nodes to target.
+func New(target selector.Rebalancer, opts ...Option) *Merger {
+	m := &Merger{target: target}
+	for _, opt := range opts {
+		opt(&m.opts)
+	}
+	return m
+}
+
+// Source returns the discovery source named name,
+// a new source is registered with the lowest precedence so far.
+func (m *Merger) Source(name string) selector.Rebalancer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sources {
+		if s.name == name {
+			return s
+		}
+	}
+	s := &source{name: name, merger: m}
+	m.sources = append(m.sources, s)
+	return s
+}
+
+// Nodes returns the current merged nodes.
+func (m *Merger) Nodes() []selector.Node {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.merge()
+}
+
+func (m *Merger) update(s *source, nodes []selector.Node) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.nodes = nodes
+	m.target.Apply(m.merge())
+}
+
+// merge must be called with m.mu held.
+func (m *Merger) merge() []selector.Node {
+	var (
+		index  = make(map[string]int)
+		merged = make([]selector.Node, 0)
+	)
+	for _, s := range m.sources {
+		for _, n := range s.nodes {
+			i, ok := index[n.Address()]
+			if !ok {
+				index[n.Address()] = len(merged)
+				merged = append(merged, n)
+				continue
+			}
+			if m.opts.policy == MergeMetadata {
+				merged[i] = mergeNode(merged[i], n)
+			}
+		}
+	}
+	// keep a stable order, balancers like wrr are sensitive to it.
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Address() < merged[j].Address()
+	})
+	return merged
+}
+
+// source is a single discovery source of merger.
+type source struct {
+	name   string
+	merger *Merger
+	nodes  []selector.Node
+}
+
+// Apply is apply all nodes of this source when any changes happen
+func (s *source) Apply(nodes []selector.Node) {
+	s.merger.update(s, nodes)
+}
+
+// node is a node whose metadata merged from several sources.
+type node struct {
+	selector.Node
+
+	weight   *int64
+	metadata map[string]string
+}
+
+// InitialWeight is the initial weight of the first source which has set it.
+func (n *node) InitialWeight() *int64 {
+	return n.weight
+}
+
+// Metadata is the merged metadata.
+func (n *node) Metadata() map[string]string {
+	return n.metadata
+}
+
+// mergeNode merge the metadata of lower into higher, the keys of higher are kept.
+func mergeNode(higher, lower selector.Node) selector.Node {
+	md := make(map[string]string, len(higher.Metadata())+len(lower.Metadata()))
+	for k, v := range lower.Metadata() {
+		md[k] = v
+	}
+	for k, v := range higher.Metadata() {
+		md[k] = v
+	}
+	weight := higher.InitialWeight()
+	if weight == nil {
+		weight = lower.InitialWeight()
+	}
+	if n, ok := higher.(*node); ok {
+		higher = n.Node
+	}
+	return &node{Node: higher, weight: weight, metadata: md}
+}
@@ -0,0 +1,58 @@
+package merge_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/merge"
+	"github.com/omalloc/proxy/selector/random"
+)
+
+func TestMergePreferFirst(t *testing.T) {
+	s := random.New()
+	m := merge.New(s)
+	dns := m.Source("dns")
+	static := m.Source("static")
+
+	static.Apply([]selector.Node{
+		selector.NewNode("http", "127.0.0.1:8080", selector.RawMetadata("zone", "static")),
+		selector.NewNode("http", "127.0.0.1:8081", nil),
+	})
+	assert.Len(t, m.Nodes(), 2)
+
+	dns.Apply([]selector.Node{
+		selector.NewNode("http", "127.0.0.1:8080", selector.RawMetadata("zone", "dns")),
+		selector.NewNode("http", "127.0.0.1:8082", nil),
+	})
+	assert.Len(t, m.Nodes(), 3)
+	assert.Len(t, s.(selector.NodeLister).Nodes(), 3)
+	assert.Equal(t, "127.0.0.1:8080", m.Nodes()[0].Address())
+	assert.Equal(t, "dns", m.Nodes()[0].Metadata()["zone"])
+
+	// dynamic discovery is flaky, the static set is kept.
+	dns.Apply(nil)
+	assert.Len(t, m.Nodes(), 2)
+	assert.Equal(t, "static", m.Nodes()[0].Metadata()["zone"])
+	assert.Equal(t, dns, m.Source("dns"))
+}
+
+func TestMergeMetadata(t *testing.T) {
+	s := random.New()
+	m := merge.New(s, merge.WithPolicy(merge.MergeMetadata))
+	dns := m.Source("dns")
+	static := m.Source("static")
+
+	dns.Apply([]selector.Node{
+		selector.NewNode("http", "127.0.0.1:8080", selector.RawMetadata("zone", "dns")),
+	})
+	static.Apply([]selector.Node{
+		selector.NewNode("http", "127.0.0.1:8080", selector.RawMetadata("zone", "static", "weight", "10")),
+	})
+
+	assert.Len(t, m.Nodes(), 1)
+	assert.Equal(t, "dns", m.Nodes()[0].Metadata()["zone"])
+	assert.Equal(t, "10", m.Nodes()[0].Metadata()["weight"])
+	assert.Equal(t, int64(10), *m.Nodes()[0].InitialWeight())
+}
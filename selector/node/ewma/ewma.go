@@ -12,9 +12,15 @@ import (
 
 const (
 	// The mean lifetime of `cost`, it reaches its half-life after Tau*ln(2).
-	tau = int64(time.Millisecond * 600)
+	tau = time.Millisecond * 600
 	// if statistic not collected,we add a big lag penalty to endpoint
-	penalty = uint64(time.Microsecond * 100)
+	penalty = time.Microsecond * 100
+	// add 5ms to eliminate the latency gap between different zones
+	zoneOffset = time.Millisecond * 5
+	// the number of slots tracking the start of requests in flight
+	inflightSlots = 200
+	// the success ratio below which a node is unhealthy
+	minHealthySuccess = 0.5
 )
 
 var (
@@ -27,10 +33,15 @@ type Node struct {
 	selector.Node
 
 	// client statistic data
-	lag       int64
+	lag int64
+	// connLag is the moving average of the connection setup per request, 0 on a reused
+	// connection, and ttfb of the time to first byte, both from selector.DoneInfo.Timing
+	// and TTFB, so that network trouble is told from slow handlers.
+	connLag   int64
+	ttfb      int64
 	success   uint64
 	inflight  int64
-	inflights [200]int64
+	inflights []int64
 	// last collected timestamp
 	stamp int64
 	// request number in a period time
@@ -38,7 +49,10 @@ type Node struct {
 	// last lastPick timestamp
 	lastPick int64
 
-	errHandler   func(err error) (isErr bool)
+	tau          int64
+	penalty      uint64
+	zoneOffset   int64
+	classifier   Classifier
 	cachedWeight *atomic.Value
 }
 
@@ -47,9 +61,83 @@ type nodeWeight struct {
 	updateAt int64
 }
 
+// Classifier reports whether the result of a request is a failure.
+type Classifier func(di selector.DoneInfo) (isErr bool)
+
+// DefaultClassifier treats network errors, timeouts and cancellations as failures.
+func DefaultClassifier(di selector.DoneInfo) bool {
+	if di.Err == nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(di.Err, context.DeadlineExceeded) ||
+		errors.Is(di.Err, context.Canceled) ||
+		errors.As(di.Err, &netErr)
+}
+
+// HTTPClassifier treats 5xx and 429 responses as failures in addition to DefaultClassifier.
+func HTTPClassifier(di selector.DoneInfo) bool {
+	return DefaultClassifier(di) || di.StatusCode >= 500 || di.StatusCode == 429
+}
+
+// Option is ewma builder option.
+type Option func(b *Builder)
+
+// WithTau set the mean lifetime of the moving averages, default is 600ms.
+func WithTau(d time.Duration) Option {
+	return func(b *Builder) {
+		b.tau = d
+	}
+}
+
+// WithPenalty set the latency assumed before any is observed, default is 100us, 0 disables it
+// so that the nodes without observed latency weigh the most.
+func WithPenalty(d time.Duration) Option {
+	return func(b *Builder) {
+		b.penalty = &d
+	}
+}
+
+// WithZoneOffset set the latency added to even out the gap between zones, default is 5ms, 0 disables it.
+func WithZoneOffset(d time.Duration) Option {
+	return func(b *Builder) {
+		b.zoneOffset = &d
+	}
+}
+
+// WithInflightSlots set the number of requests in flight tracked to predict a slow node, default is 200.
+func WithInflightSlots(n int) Option {
+	return func(b *Builder) {
+		b.inflightSlots = n
+	}
+}
+
+// WithClassifier set the failure classifier, default is DefaultClassifier.
+func WithClassifier(c Classifier) Option {
+	return func(b *Builder) {
+		b.classifier = c
+	}
+}
+
 // Builder is ewma node builder.
 type Builder struct {
+	// ErrHandler reports whether err is a failure in addition to the classifier.
 	ErrHandler func(err error) (isErr bool)
+
+	tau           time.Duration
+	penalty       *time.Duration
+	zoneOffset    *time.Duration
+	inflightSlots int
+	classifier    Classifier
+}
+
+// NewBuilder create an ewma node builder.
+func NewBuilder(opts ...Option) *Builder {
+	b := &Builder{}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
 // Build create a weighted node.
@@ -59,12 +147,42 @@ func (b *Builder) Build(n selector.Node) selector.WeightedNode {
 		lag:          0,
 		success:      1000,
 		inflight:     1,
-		errHandler:   b.ErrHandler,
+		inflights:    make([]int64, orDefault(b.inflightSlots, inflightSlots)),
+		tau:          int64(orDefault(b.tau, tau)),
+		penalty:      uint64(orSet(b.penalty, penalty)),
+		zoneOffset:   int64(orSet(b.zoneOffset, zoneOffset)),
+		classifier:   b.classify,
 		cachedWeight: &atomic.Value{},
 	}
 	return s
 }
 
+func (b *Builder) classify(di selector.DoneInfo) bool {
+	if b.ErrHandler != nil && di.Err != nil && b.ErrHandler(di.Err) {
+		return true
+	}
+	if b.classifier != nil {
+		return b.classifier(di)
+	}
+	return DefaultClassifier(di)
+}
+
+// orDefault returns v if it is positive, def otherwise.
+func orDefault[T int | time.Duration](v, def T) T {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+// orSet returns *v if it is set, not below 0, def otherwise.
+func orSet(v *time.Duration, def time.Duration) time.Duration {
+	if v != nil {
+		return max(*v, 0)
+	}
+	return def
+}
+
 func (n *Node) health() uint64 {
 	return atomic.LoadUint64(&n.success)
 }
@@ -76,14 +194,16 @@ func (n *Node) load() (load uint64) {
 
 	if avgLag == 0 {
 		// penalty is the penalty value when there is no data when the node is just started.
-		load = penalty * uint64(atomic.LoadInt64(&n.inflight))
+		load = n.penalty * uint64(atomic.LoadInt64(&n.inflight))
 		return
 	}
 	if predict > avgLag {
 		avgLag = predict
 	}
-	// add 5ms to eliminate the latency gap between different zones
-	avgLag += int64(time.Millisecond * 5)
+	// a slow connection setup weighs in even if most requests reuse connections
+	avgLag += atomic.LoadInt64(&n.connLag)
+	// eliminate the latency gap between different zones
+	avgLag += n.zoneOffset
 	avgLag = int64(math.Sqrt(float64(avgLag)))
 	load = uint64(avgLag) * uint64(atomic.LoadInt64(&n.inflight))
 	return load
@@ -118,13 +238,16 @@ func (n *Node) Pick() selector.DoneFunc {
 	atomic.StoreInt64(&n.lastPick, start)
 	atomic.AddInt64(&n.inflight, 1)
 	reqs := atomic.AddInt64(&n.reqs, 1)
-	slot := reqs % 200
+	slot := reqs % int64(len(n.inflights))
 	swapped := atomic.CompareAndSwapInt64(&n.inflights[slot], 0, start)
 	return func(ctx context.Context, di selector.DoneInfo) {
 		if swapped {
 			atomic.CompareAndSwapInt64(&n.inflights[slot], start, 0)
 		}
 		atomic.AddInt64(&n.inflight, -1)
+		if errors.Is(di.Err, selector.ErrDiscarded) {
+			return
+		}
 
 		now := time.Now().UnixNano()
 		// get moving average ratio w
@@ -133,7 +256,7 @@ func (n *Node) Pick() selector.DoneFunc {
 		if td < 0 {
 			td = 0
 		}
-		w := math.Exp(float64(-td) / float64(tau))
+		w := math.Exp(float64(-td) / float64(n.tau))
 
 		lag := now - start
 		if lag < 0 {
@@ -146,17 +269,15 @@ func (n *Node) Pick() selector.DoneFunc {
 		lag = int64(float64(oldLag)*w + float64(lag)*(1.0-w))
 		atomic.StoreInt64(&n.lag, lag)
 
+		if di.TTFB > 0 {
+			setup := di.Timing.DNS + di.Timing.Connect + di.Timing.TLSHandshake
+			atomic.StoreInt64(&n.connLag, int64(float64(atomic.LoadInt64(&n.connLag))*w+float64(setup)*(1.0-w)))
+			atomic.StoreInt64(&n.ttfb, int64(float64(atomic.LoadInt64(&n.ttfb))*w+float64(di.TTFB)*(1.0-w)))
+		}
+
 		success := uint64(1000) // error value ,if error set 1
-		if di.Err != nil {
-			if n.errHandler != nil && n.errHandler(di.Err) {
-				success = 0
-			}
-			var netErr net.Error
-			if errors.Is(context.DeadlineExceeded, di.Err) ||
-				errors.Is(context.Canceled, di.Err) ||
-				errors.As(di.Err, &netErr) {
-				success = 0
-			}
+		if n.classifier(di) {
+			success = 0
 		}
 		oldSuc := atomic.LoadUint64(&n.success)
 		success = uint64(float64(oldSuc)*w + float64(success)*(1.0-w))
@@ -170,7 +291,8 @@ func (n *Node) Weight() (weight float64) {
 	now := time.Now().UnixNano()
 	if !ok || time.Duration(now-w.updateAt) > (time.Millisecond*5) {
 		health := n.health()
-		load := n.load()
+		// the load is 0 before any latency is observed if the penalty is disabled
+		load := max(n.load(), 1)
 		weight = float64(health*uint64(time.Microsecond)*10) / float64(load)
 		n.cachedWeight.Store(&nodeWeight{
 			value:    weight,
@@ -182,6 +304,32 @@ func (n *Node) Weight() (weight float64) {
 	return
 }
 
+// Lag is the moving average of the latency.
+func (n *Node) Lag() time.Duration {
+	return time.Duration(atomic.LoadInt64(&n.lag))
+}
+
+// ConnectLag is the moving average of the DNS, connect and TLS handshake time per request,
+// which is 0 on a reused connection.
+func (n *Node) ConnectLag() time.Duration {
+	return time.Duration(atomic.LoadInt64(&n.connLag))
+}
+
+// TTFB is the moving average of the time to first byte.
+func (n *Node) TTFB() time.Duration {
+	return time.Duration(atomic.LoadInt64(&n.ttfb))
+}
+
+// Success is the moving average of the success ratio in [0, 1].
+func (n *Node) Success() float64 {
+	return float64(n.health()) / 1000
+}
+
+// Healthy reports whether the success ratio is at least 0.5.
+func (n *Node) Healthy() bool {
+	return n.Success() >= minHealthySuccess
+}
+
 func (n *Node) PickElapsed() time.Duration {
 	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&n.lastPick))
 }
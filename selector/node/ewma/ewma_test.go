@@ -0,0 +1,114 @@
+package ewma_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/node/ewma"
+)
+
+func TestClassifier(t *testing.T) {
+	unavailable := selector.DoneInfo{StatusCode: http.StatusServiceUnavailable}
+	assert.False(t, ewma.DefaultClassifier(unavailable))
+	assert.True(t, ewma.HTTPClassifier(unavailable))
+	assert.True(t, ewma.HTTPClassifier(selector.DoneInfo{StatusCode: http.StatusTooManyRequests}))
+	assert.True(t, ewma.DefaultClassifier(selector.DoneInfo{Err: context.DeadlineExceeded}))
+	assert.False(t, ewma.HTTPClassifier(selector.DoneInfo{StatusCode: http.StatusNotFound}))
+
+	for _, tt := range []struct {
+		builder *ewma.Builder
+		success bool
+	}{
+		{ewma.NewBuilder(), true},
+		{ewma.NewBuilder(ewma.WithClassifier(ewma.HTTPClassifier)), false},
+	} {
+		n := tt.builder.Build(selector.NewNode("http", "127.0.0.1:8080", nil)).(*ewma.Node)
+		n.Pick()(context.Background(), unavailable)
+		assert.Equal(t, tt.success, n.Success() == 1)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	b := ewma.NewBuilder(
+		ewma.WithTau(time.Second),
+		ewma.WithPenalty(time.Millisecond),
+		ewma.WithZoneOffset(0),
+		ewma.WithInflightSlots(4),
+	)
+	n := b.Build(selector.NewNode("http", "127.0.0.1:8080", nil)).(*ewma.Node)
+	// no latency observed yet, the penalty is the latency
+	assert.Equal(t, 10.0, n.Weight())
+
+	// more picks than slots
+	var dones []selector.DoneFunc
+	for i := 0; i < 10; i++ {
+		dones = append(dones, n.Pick())
+	}
+	for _, done := range dones {
+		done(context.Background(), selector.DoneInfo{})
+	}
+	assert.Greater(t, n.Lag(), time.Duration(0))
+
+	// an explicit 0 disables the penalty and the zone offset
+	n = ewma.NewBuilder(ewma.WithPenalty(0)).Build(selector.NewNode("http", "127.0.0.1:8080", nil)).(*ewma.Node)
+	weight := n.Weight()
+	assert.False(t, math.IsInf(weight, 0) || math.IsNaN(weight))
+	assert.Greater(t, weight, ewma.NewBuilder().Build(selector.NewNode("http", "127.0.0.1:8081", nil)).Weight())
+
+	zero := ewma.NewBuilder(ewma.WithZoneOffset(0)).Build(selector.NewNode("http", "127.0.0.1:8080", nil)).(*ewma.Node)
+	def := ewma.NewBuilder().Build(selector.NewNode("http", "127.0.0.1:8081", nil)).(*ewma.Node)
+	zero.Pick()(context.Background(), selector.DoneInfo{})
+	def.Pick()(context.Background(), selector.DoneInfo{})
+	// the default 5ms offset outweighs the observed latency
+	assert.Greater(t, zero.Weight(), 2*def.Weight())
+}
+
+func TestDiscarded(t *testing.T) {
+	n := ewma.NewBuilder().Build(selector.NewNode("http", "127.0.0.1:8080", nil)).(*ewma.Node)
+	weight := n.Weight()
+
+	n.Pick()(context.Background(), selector.DoneInfo{Err: fmt.Errorf("saturated: %w", selector.ErrDiscarded)})
+	assert.Equal(t, time.Duration(0), n.Lag())
+	assert.Equal(t, 1.0, n.Success())
+	time.Sleep(6 * time.Millisecond)
+	assert.Equal(t, weight, n.Weight())
+}
+
+func TestHealthy(t *testing.T) {
+	n := ewma.NewBuilder(ewma.WithTau(time.Millisecond)).Build(selector.NewNode("http", "127.0.0.1:8080", nil)).(*ewma.Node)
+	assert.True(t, n.Healthy())
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(2 * time.Millisecond)
+		n.Pick()(context.Background(), selector.DoneInfo{Err: context.DeadlineExceeded})
+	}
+	assert.False(t, n.Healthy())
+}
+
+func TestTiming(t *testing.T) {
+	b := ewma.NewBuilder(ewma.WithZoneOffset(0))
+	slow := b.Build(selector.NewNode("http", "127.0.0.1:8080", nil)).(*ewma.Node)
+	fast := b.Build(selector.NewNode("http", "127.0.0.1:8081", nil)).(*ewma.Node)
+
+	// the same handler time, but a slow connection setup on one node
+	slow.Pick()(context.Background(), selector.DoneInfo{
+		TTFB:   60 * time.Millisecond,
+		Timing: selector.Timing{DNS: 10 * time.Millisecond, Connect: 40 * time.Millisecond},
+	})
+	fast.Pick()(context.Background(), selector.DoneInfo{
+		TTFB:   10 * time.Millisecond,
+		Timing: selector.Timing{ConnReused: true},
+	})
+	assert.Equal(t, 50*time.Millisecond, slow.ConnectLag())
+	assert.Equal(t, 60*time.Millisecond, slow.TTFB())
+	assert.Equal(t, time.Duration(0), fast.ConnectLag())
+	assert.Equal(t, 10*time.Millisecond, fast.TTFB())
+	assert.Greater(t, fast.Weight(), slow.Weight())
+}
@@ -0,0 +1,219 @@
+package orca
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/node/ewma"
+)
+
+const (
+	// Header is the default response header or trailer of the load report.
+	Header = "Endpoint-Load-Metrics"
+
+	// keys of the load report
+	KeyCPU         = "cpu_utilization"
+	KeyApplication = "application_utilization"
+	KeyQueue       = "queue"
+
+	// defaultMaxAge is the age after which a report is ignored.
+	defaultMaxAge = 10 * time.Second
+	// minFactor keeps a fully utilized node pickable.
+	minFactor = 0.01
+	// maxHealthyUtilization is the utilization above which a node is unhealthy.
+	maxHealthyUtilization = 0.95
+)
+
+var (
+	_ selector.WeightedNode        = (*Node)(nil)
+	_ selector.WeightedNodeBuilder = (*Builder)(nil)
+)
+
+// Report is the load reported by a backend.
+type Report struct {
+	// CPU is the cpu utilization in [0, 1].
+	CPU float64
+	// Application is the utilization defined by the application in [0, 1], it takes
+	// precedence over CPU if set.
+	Application float64
+	// Queue is the number of requests queued in the backend.
+	Queue float64
+}
+
+// Parse parses a report in the text format "cpu_utilization=0.3, queue=2",
+// an optional "TEXT " prefix is accepted and unknown keys are ignored.
+func Parse(s string) (Report, bool) {
+	var (
+		r  Report
+		ok bool
+	)
+	s = strings.TrimPrefix(strings.TrimSpace(s), "TEXT ")
+	for _, kv := range strings.Split(s, ",") {
+		k, v, found := strings.Cut(strings.TrimSpace(kv), "=")
+		if !found {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case KeyCPU:
+			r.CPU = f
+		case KeyApplication:
+			r.Application = f
+		case KeyQueue:
+			r.Queue = f
+		default:
+			continue
+		}
+		ok = true
+	}
+	return r, ok
+}
+
+// String formats the report in the text format, for backends to set the header.
+func (r Report) String() string {
+	var b strings.Builder
+	add := func(k string, v float64) {
+		if v == 0 {
+			return
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	add(KeyCPU, r.CPU)
+	add(KeyApplication, r.Application)
+	add(KeyQueue, r.Queue)
+	return b.String()
+}
+
+// Utilization is the application utilization if set, the cpu utilization otherwise.
+func (r Report) Utilization() float64 {
+	if r.Application > 0 {
+		return r.Application
+	}
+	return r.CPU
+}
+
+// Builder is orca node builder.
+type Builder struct {
+	// Header is the response header or trailer of the load report, default is Header.
+	Header string
+	// MaxAge is the age after which a report is ignored, default is 10s.
+	MaxAge time.Duration
+	// Node builds the node collecting the client-side statistic, default is the ewma node.
+	Node selector.WeightedNodeBuilder
+}
+
+// Build create a weighted node.
+func (b *Builder) Build(n selector.Node) selector.WeightedNode {
+	node := &Node{
+		header: b.Header,
+		maxAge: b.MaxAge,
+	}
+	if node.header == "" {
+		node.header = Header
+	}
+	if node.maxAge <= 0 {
+		node.maxAge = defaultMaxAge
+	}
+	inner := b.Node
+	if inner == nil {
+		inner = &ewma.Builder{}
+	}
+	node.WeightedNode = inner.Build(n)
+	return node
+}
+
+// report is a report with the time it is received.
+type report struct {
+	Report
+	at time.Time
+}
+
+// Node blends the load reported by the backend with the client-side statistic:
+// the weight of the inner node is scaled by the idle ratio and divided by the queue.
+type Node struct {
+	selector.WeightedNode
+
+	header string
+	maxAge time.Duration
+	report atomic.Pointer[report]
+}
+
+// Pick pick a node.
+func (n *Node) Pick() selector.DoneFunc {
+	done := n.WeightedNode.Pick()
+	return func(ctx context.Context, di selector.DoneInfo) {
+		if di.ReplyMD != nil {
+			if r, ok := Parse(di.ReplyMD.Get(n.header)); ok {
+				n.report.Store(&report{Report: r, at: time.Now()})
+			}
+		}
+		done(ctx, di)
+	}
+}
+
+// Weight is node effective weight.
+func (n *Node) Weight() float64 {
+	return n.WeightedNode.Weight() * n.factor()
+}
+
+// factor is the weight factor of the last report, 1 if none or it is too old.
+func (n *Node) factor() float64 {
+	r, ok := n.Report()
+	if !ok {
+		return 1
+	}
+	return math.Max(1-math.Min(r.Utilization(), 1), minFactor) / (1 + r.Queue)
+}
+
+// Report is the last load reported by the backend, false if none or it is too old.
+func (n *Node) Report() (Report, bool) {
+	r := n.report.Load()
+	if r == nil || time.Since(r.at) > n.maxAge {
+		return Report{}, false
+	}
+	return r.Report, true
+}
+
+// Utilization is the utilization in the last report, zero if none.
+func (n *Node) Utilization() float64 {
+	r, _ := n.Report()
+	return r.Utilization()
+}
+
+// Healthy reports whether the utilization in the last report is below 0.95
+// and the inner node is healthy.
+func (n *Node) Healthy() bool {
+	if h, ok := n.WeightedNode.(interface{ Healthy() bool }); ok && !h.Healthy() {
+		return false
+	}
+	return n.Utilization() < maxHealthyUtilization
+}
+
+// Lag is the moving average of the latency of the inner node, zero if unknown.
+func (n *Node) Lag() time.Duration {
+	if r, ok := n.WeightedNode.(interface{ Lag() time.Duration }); ok {
+		return r.Lag()
+	}
+	return 0
+}
+
+// Success is the moving average of the success ratio of the inner node, 1 if unknown.
+func (n *Node) Success() float64 {
+	if r, ok := n.WeightedNode.(interface{ Success() float64 }); ok {
+		return r.Success()
+	}
+	return 1
+}
@@ -0,0 +1,53 @@
+package orca_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/node/direct"
+	"github.com/omalloc/proxy/selector/node/orca"
+)
+
+type replyMD map[string]string
+
+func (md replyMD) Get(key string) string {
+	return md[key]
+}
+
+func TestParse(t *testing.T) {
+	r, ok := orca.Parse("TEXT cpu_utilization=0.3, application_utilization=0.5, queue=2, rps=10")
+	assert.True(t, ok)
+	assert.Equal(t, orca.Report{CPU: 0.3, Application: 0.5, Queue: 2}, r)
+	assert.Equal(t, 0.5, r.Utilization())
+	assert.Equal(t, "cpu_utilization=0.3, application_utilization=0.5, queue=2", r.String())
+
+	_, ok = orca.Parse("cpu_utilization=abc")
+	assert.False(t, ok)
+	_, ok = orca.Parse("")
+	assert.False(t, ok)
+}
+
+func TestNodeWeight(t *testing.T) {
+	b := &orca.Builder{Node: &direct.Builder{}}
+	n := b.Build(selector.NewNode("http", "127.0.0.1:8080", nil))
+	assert.Equal(t, 100.0, n.Weight())
+
+	done := n.Pick()
+	done(context.Background(), selector.DoneInfo{ReplyMD: replyMD{orca.Header: "cpu_utilization=0.75"}})
+	assert.Equal(t, 25.0, n.Weight())
+	assert.Equal(t, 0.75, n.(*orca.Node).Utilization())
+	assert.True(t, n.(*orca.Node).Healthy())
+
+	done = n.Pick()
+	done(context.Background(), selector.DoneInfo{ReplyMD: replyMD{orca.Header: "cpu_utilization=1, queue=1"}})
+	assert.InDelta(t, 0.5, n.Weight(), 1e-9)
+	assert.False(t, n.(*orca.Node).Healthy())
+
+	// no report keeps the last one
+	done = n.Pick()
+	done(context.Background(), selector.DoneInfo{})
+	assert.InDelta(t, 0.5, n.Weight(), 1e-9)
+}
@@ -0,0 +1,97 @@
+package selector
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Observer is the callbacks on selection, completion and pool changes,
+// any of the callbacks may be nil.
+type Observer struct {
+	// OnSelect is called after a node is selected.
+	OnSelect func(ctx context.Context, node Node)
+	// OnDone is called after the request to node is done.
+	OnDone func(ctx context.Context, node Node, di DoneInfo, latency time.Duration)
+	// OnApply is called after the nodes changed.
+	OnApply func(old, new []Node)
+	// OnNoAvailable is called when no node can be selected.
+	OnNoAvailable func(ctx context.Context)
+}
+
+// Observable is implemented by selectors which accept observers, e.g. the default selector.
+type Observable interface {
+	Observe(o Observer)
+}
+
+// Observers is a list of observers, safe for concurrent use.
+// The zero value is ready to use and notifying an empty list costs a single atomic load.
+type Observers struct {
+	mu   sync.Mutex
+	list atomic.Pointer[[]Observer]
+}
+
+// Add register an observer.
+func (os *Observers) Add(o Observer) {
+	os.mu.Lock()
+	defer os.mu.Unlock()
+
+	var list []Observer
+	if old := os.list.Load(); old != nil {
+		list = append(list, *old...)
+	}
+	list = append(list, o)
+	os.list.Store(&list)
+}
+
+// Len returns the number of registered observers.
+func (os *Observers) Len() int {
+	if list := os.list.Load(); list != nil {
+		return len(*list)
+	}
+	return 0
+}
+
+func (os *Observers) load() []Observer {
+	if list := os.list.Load(); list != nil {
+		return *list
+	}
+	return nil
+}
+
+// NotifySelect calls OnSelect of every observer.
+func (os *Observers) NotifySelect(ctx context.Context, node Node) {
+	for _, o := range os.load() {
+		if o.OnSelect != nil {
+			o.OnSelect(ctx, node)
+		}
+	}
+}
+
+// NotifyDone calls OnDone of every observer.
+func (os *Observers) NotifyDone(ctx context.Context, node Node, di DoneInfo, latency time.Duration) {
+	for _, o := range os.load() {
+		if o.OnDone != nil {
+			o.OnDone(ctx, node, di, latency)
+		}
+	}
+}
+
+// NotifyApply calls OnApply of every observer.
+func (os *Observers) NotifyApply(old, new []Node) {
+	for _, o := range os.load() {
+		if o.OnApply != nil {
+			o.OnApply(old, new)
+		}
+	}
+}
+
+// NotifyNoAvailable calls OnNoAvailable of every observer.
+func (os *Observers) NotifyNoAvailable(ctx context.Context) {
+	for _, o := range os.load() {
+		if o.OnNoAvailable != nil {
+			o.OnNoAvailable(ctx)
+		}
+	}
+}
@@ -14,11 +14,24 @@ const (
 
 var _ selector.Balancer = (*Balancer)(nil) // Name is balancer name
 
+func init() {
+	selector.Register(Name, NewBuilder())
+}
+
 // Option is once builder option.
 type Option func(o *options)
 
 // options is once builder options
-type options struct{}
+type options struct {
+	node selector.WeightedNodeBuilder
+}
+
+// WithNodeBuilder set the weighted node builder, default is the direct node builder.
+func WithNodeBuilder(b selector.WeightedNodeBuilder) Option {
+	return func(o *options) {
+		o.node = b
+	}
+}
 
 // Balancer is a once balancer.
 type Balancer struct {
@@ -29,6 +42,11 @@ func New(opts ...Option) selector.Selector {
 	return NewBuilder(opts...).Build()
 }
 
+// Name is balancer name
+func (p *Balancer) Name() string {
+	return Name
+}
+
 // Pick is pick a weighted node.
 func (p *Balancer) Pick(_ context.Context, nodes []selector.WeightedNode) (selector.WeightedNode, selector.DoneFunc, error) {
 	return nil, nil, nil
@@ -40,9 +58,13 @@ func NewBuilder(opts ...Option) selector.Builder {
 	for _, opt := range opts {
 		opt(&option)
 	}
+	node := option.node
+	if node == nil {
+		node = &direct.Builder{}
+	}
 	return &selector.StaticNodeBuilder{
 		Balancer: &Builder{},
-		Node:     &direct.Builder{},
+		Node:     node,
 	}
 }
 
@@ -2,12 +2,14 @@ package p2c
 
 import (
 	"context"
-	"github.com/omalloc/proxy/selector"
-	"github.com/omalloc/proxy/selector/node/ewma"
 	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/internal/rnd"
+	"github.com/omalloc/proxy/selector/node/ewma"
 )
 
 const (
@@ -18,11 +20,62 @@ const (
 
 var _ selector.Balancer = (*Balancer)(nil)
 
+func init() {
+	selector.Register(Name, NewBuilder())
+}
+
 // Option is p2c builder option.
 type Option func(o *options)
 
 // options is p2c builder options
-type options struct{}
+type options struct {
+	ewma      []ewma.Option
+	node      selector.WeightedNodeBuilder
+	source    rand.Source
+	forcePick time.Duration
+	choices   int
+}
+
+// WithEWMA set the options of the ewma nodes.
+func WithEWMA(opts ...ewma.Option) Option {
+	return func(o *options) {
+		o.ewma = append(o.ewma, opts...)
+	}
+}
+
+// WithNodeBuilder set the weighted node builder, default is the ewma node builder.
+func WithNodeBuilder(b selector.WeightedNodeBuilder) Option {
+	return func(o *options) {
+		o.node = b
+	}
+}
+
+// WithSource set the random source, default is seeded by the current time.
+func WithSource(src rand.Source) Option {
+	return func(o *options) {
+		o.source = src
+	}
+}
+
+// WithSeed set the seed of the random source, for deterministic picks in tests.
+func WithSeed(seed int64) Option {
+	return WithSource(rand.NewSource(seed))
+}
+
+// WithForcePick set the interval after which an unpicked node is forced to be picked once
+// to refresh its statistic, default is 3s, a negative interval disables it.
+func WithForcePick(d time.Duration) Option {
+	return func(o *options) {
+		o.forcePick = d
+	}
+}
+
+// WithChoices set the number of random nodes the best is picked from, default is 2.
+func WithChoices(n int) Option {
+	return func(o *options) {
+		o.choices = n
+	}
+}
 
 // New creates a p2c selector.
 func New(opts ...Option) selector.Selector {
@@ -31,22 +84,29 @@ func New(opts ...Option) selector.Selector {
 
 // Balancer is p2c selector.
 type Balancer struct {
-	mu     sync.Mutex
-	r      *rand.Rand
-	picked int64
+	mu        sync.Mutex
+	r         *rand.Rand
+	picked    int64
+	forcePick time.Duration
+	choices   int
+}
+
+// Name is balancer name
+func (s *Balancer) Name() string {
+	return Name
 }
 
-// choose two distinct nodes.
-func (s *Balancer) prePick(nodes []selector.WeightedNode) (nodeA selector.WeightedNode, nodeB selector.WeightedNode) {
+// prePick choose n distinct nodes.
+func (s *Balancer) prePick(nodes []selector.WeightedNode, n int) []selector.WeightedNode {
 	s.mu.Lock()
-	a := s.r.Intn(len(nodes))
-	b := s.r.Intn(len(nodes) - 1)
+	chosen := rnd.Sample(s.r, len(nodes), n)
 	s.mu.Unlock()
-	if b >= a {
-		b = b + 1
+
+	picked := make([]selector.WeightedNode, n)
+	for i, c := range chosen {
+		picked[i] = nodes[c]
 	}
-	nodeA, nodeB = nodes[a], nodes[b]
-	return
+	return picked
 }
 
 // Pick pick a node.
@@ -59,18 +119,23 @@ func (s *Balancer) Pick(_ context.Context, nodes []selector.WeightedNode) (selec
 		return nodes[0], done, nil
 	}
 
-	var pc, upc selector.WeightedNode
-	nodeA, nodeB := s.prePick(nodes)
 	// meta.Weight is the weight set by the service publisher in discovery
-	if nodeB.Weight() > nodeA.Weight() {
-		pc, upc = nodeB, nodeA
-	} else {
-		pc, upc = nodeA, nodeB
+	var pc, upc selector.WeightedNode
+	for _, n := range s.prePick(nodes, min(s.choices, len(nodes))) {
+		switch {
+		case pc == nil || n.Weight() > pc.Weight():
+			if pc != nil && (upc == nil || pc.PickElapsed() > upc.PickElapsed()) {
+				upc = pc
+			}
+			pc = n
+		case upc == nil || n.PickElapsed() > upc.PickElapsed():
+			upc = n
+		}
 	}
 
 	// If the failed node has never been selected once during forceGap, it is forced to be selected once
 	// Take advantage of forced opportunities to trigger updates of success rate and delay
-	if upc.PickElapsed() > forcePick && atomic.CompareAndSwapInt64(&s.picked, 0, 1) {
+	if s.forcePick >= 0 && upc.PickElapsed() > s.forcePick && atomic.CompareAndSwapInt64(&s.picked, 0, 1) {
 		pc = upc
 		atomic.StoreInt64(&s.picked, 0)
 	}
@@ -84,16 +149,43 @@ func NewBuilder(opts ...Option) selector.Builder {
 	for _, opt := range opts {
 		opt(&option)
 	}
+	node := option.node
+	if node == nil {
+		node = ewma.NewBuilder(option.ewma...)
+	}
 	return &selector.DefaultBuilder{
-		Balancer: &Builder{},
-		Node:     &ewma.Builder{},
+		Balancer: &Builder{
+			source:    option.source,
+			forcePick: option.forcePick,
+			choices:   option.choices,
+		},
+		Node: node,
 	}
 }
 
 // Builder is p2c builder
-type Builder struct{}
+type Builder struct {
+	source    rand.Source
+	forcePick time.Duration
+	choices   int
+}
 
 // Build creates Balancer
 func (b *Builder) Build() selector.Balancer {
-	return &Balancer{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	src := b.source
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	s := &Balancer{
+		r:         rand.New(src),
+		forcePick: b.forcePick,
+		choices:   b.choices,
+	}
+	if s.forcePick == 0 {
+		s.forcePick = forcePick
+	}
+	if s.choices < 2 {
+		s.choices = 2
+	}
+	return s
 }
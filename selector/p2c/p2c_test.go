@@ -0,0 +1,49 @@
+package p2c_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/node/direct"
+	"github.com/omalloc/proxy/selector/p2c"
+)
+
+func TestSeed(t *testing.T) {
+	nodes := []selector.Node{
+		selector.NewNode("http", "127.0.0.1:8080", nil),
+		selector.NewNode("http", "127.0.0.1:8081", nil),
+		selector.NewNode("http", "127.0.0.1:8082", nil),
+		selector.NewNode("http", "127.0.0.1:8083", nil),
+	}
+	var picks [2][]string
+	for i := range picks {
+		s := p2c.New(p2c.WithSeed(1), p2c.WithNodeBuilder(&direct.Builder{}), p2c.WithChoices(3))
+		s.Apply(nodes)
+		for j := 0; j < 20; j++ {
+			n, done, err := s.Select(context.Background())
+			assert.NoError(t, err)
+			done(context.Background(), selector.DoneInfo{})
+			picks[i] = append(picks[i], n.Address())
+		}
+	}
+	assert.Equal(t, picks[0], picks[1])
+}
+
+func TestChoices(t *testing.T) {
+	// picking from all nodes always picks the heaviest
+	s := p2c.New(p2c.WithChoices(3), p2c.WithNodeBuilder(&direct.Builder{}), p2c.WithForcePick(-1))
+	s.Apply([]selector.Node{
+		selector.NewNode("http", "127.0.0.1:8080", selector.RawMetadata("weight", "10")),
+		selector.NewNode("http", "127.0.0.1:8081", selector.RawMetadata("weight", "30")),
+		selector.NewNode("http", "127.0.0.1:8082", selector.RawMetadata("weight", "20")),
+	})
+	for i := 0; i < 10; i++ {
+		n, done, err := s.Select(context.Background())
+		assert.NoError(t, err)
+		done(context.Background(), selector.DoneInfo{})
+		assert.Equal(t, "127.0.0.1:8081", n.Address())
+	}
+}
@@ -0,0 +1,195 @@
+// Package powerofd is the power of d choices balancer. p2c.WithChoices samples d nodes too,
+// but always compares them by the node weight and forces the pick of stale nodes. This
+// balancer compares them by a pluggable Metric instead, such as Inflight, the requests in
+// flight it tracks per node, which needs no latency statistic of the nodes.
+package powerofd
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/internal/rnd"
+	"github.com/omalloc/proxy/selector/node/ewma"
+)
+
+const (
+	// Name is power of d choices balancer name
+	Name = "power_of_d"
+
+	defaultChoices = 2
+)
+
+var _ selector.Balancer = (*Balancer)(nil)
+
+// Metric is the cost of a node to compare the sampled nodes by, the lowest cost is picked.
+// inflight is the requests in flight to the node sent by this balancer.
+type Metric func(n selector.WeightedNode, inflight int64) float64
+
+// Weight prefers the heaviest node, e.g. the least ewma load with the ewma nodes.
+func Weight(n selector.WeightedNode, _ int64) float64 {
+	return -n.Weight()
+}
+
+// Inflight prefers the node of the least requests in flight.
+func Inflight(_ selector.WeightedNode, inflight int64) float64 {
+	return float64(inflight)
+}
+
+// WeightedInflight prefers the node of the least requests in flight per weight.
+func WeightedInflight(n selector.WeightedNode, inflight int64) float64 {
+	w := n.Weight()
+	if w <= 0 {
+		return float64(inflight+1) * 1e12
+	}
+	return float64(inflight+1) / w
+}
+
+func init() {
+	selector.Register(Name, NewBuilder())
+}
+
+// Option is power of d builder option.
+type Option func(o *options)
+
+// options is power of d builder options
+type options struct {
+	choices int
+	metric  Metric
+	node    selector.WeightedNodeBuilder
+	source  rand.Source
+}
+
+// WithChoices set the number of nodes sampled per pick, default is 2.
+func WithChoices(d int) Option {
+	return func(o *options) {
+		o.choices = d
+	}
+}
+
+// WithMetric set the metric the sampled nodes are compared by, default is Weight.
+func WithMetric(m Metric) Option {
+	return func(o *options) {
+		o.metric = m
+	}
+}
+
+// WithNodeBuilder set the weighted node builder, default is the ewma node builder.
+func WithNodeBuilder(b selector.WeightedNodeBuilder) Option {
+	return func(o *options) {
+		o.node = b
+	}
+}
+
+// WithSource set the random source, default is seeded by the current time.
+func WithSource(src rand.Source) Option {
+	return func(o *options) {
+		o.source = src
+	}
+}
+
+// WithSeed set the seed of the random source, for deterministic picks in tests.
+func WithSeed(seed int64) Option {
+	return WithSource(rand.NewSource(seed))
+}
+
+// New creates a power of d selector.
+func New(opts ...Option) selector.Selector {
+	return NewBuilder(opts...).Build()
+}
+
+// Balancer samples d distinct nodes and picks the one of the lowest cost.
+type Balancer struct {
+	mu       sync.Mutex
+	r        *rand.Rand
+	choices  int
+	metric   Metric
+	inflight map[string]int64
+}
+
+// Name is balancer name
+func (s *Balancer) Name() string {
+	return Name
+}
+
+// Pick pick a node.
+func (s *Balancer) Pick(_ context.Context, nodes []selector.WeightedNode) (selector.WeightedNode, selector.DoneFunc, error) {
+	if len(nodes) == 0 {
+		return nil, nil, selector.ErrNoAvailable
+	}
+
+	var (
+		picked selector.WeightedNode
+		cost   float64
+	)
+	s.mu.Lock()
+	for _, t := range rnd.Sample(s.r, len(nodes), min(s.choices, len(nodes))) {
+		n := nodes[t]
+		if c := s.metric(n, s.inflight[n.Address()]); picked == nil || c < cost {
+			picked, cost = n, c
+		}
+	}
+	addr := picked.Address()
+	s.inflight[addr]++
+	s.mu.Unlock()
+
+	done := picked.Pick()
+	return picked, func(ctx context.Context, di selector.DoneInfo) {
+		s.mu.Lock()
+		if s.inflight[addr]--; s.inflight[addr] <= 0 {
+			delete(s.inflight, addr)
+		}
+		s.mu.Unlock()
+		done(ctx, di)
+	}, nil
+}
+
+// NewBuilder returns a selector builder with power of d balancer
+func NewBuilder(opts ...Option) selector.Builder {
+	var option options
+	for _, opt := range opts {
+		opt(&option)
+	}
+	node := option.node
+	if node == nil {
+		node = &ewma.Builder{}
+	}
+	return &selector.DefaultBuilder{
+		Balancer: &Builder{
+			choices: option.choices,
+			metric:  option.metric,
+			source:  option.source,
+		},
+		Node: node,
+	}
+}
+
+// Builder is power of d builder
+type Builder struct {
+	choices int
+	metric  Metric
+	source  rand.Source
+}
+
+// Build creates Balancer
+func (b *Builder) Build() selector.Balancer {
+	src := b.source
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	s := &Balancer{
+		r:        rand.New(src),
+		choices:  b.choices,
+		metric:   b.metric,
+		inflight: make(map[string]int64),
+	}
+	if s.choices < 1 {
+		s.choices = defaultChoices
+	}
+	if s.metric == nil {
+		s.metric = Weight
+	}
+	return s
+}
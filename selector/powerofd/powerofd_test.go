@@ -0,0 +1,53 @@
+package powerofd_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/node/direct"
+	"github.com/omalloc/proxy/selector/powerofd"
+)
+
+func TestInflight(t *testing.T) {
+	s := powerofd.New(powerofd.WithChoices(3), powerofd.WithMetric(powerofd.Inflight), powerofd.WithSeed(1))
+	s.Apply([]selector.Node{
+		selector.NewNode("http", "127.0.0.1:8080", nil),
+		selector.NewNode("http", "127.0.0.1:8081", nil),
+		selector.NewNode("http", "127.0.0.1:8082", nil),
+	})
+
+	// sampling all nodes spreads the requests in flight evenly
+	var (
+		addrs []string
+		dones []selector.DoneFunc
+	)
+	for i := 0; i < 6; i++ {
+		n, done, err := s.Select(context.Background())
+		assert.NoError(t, err)
+		addrs = append(addrs, n.Address())
+		dones = append(dones, done)
+	}
+	assert.ElementsMatch(t, addrs[:3], []string{"127.0.0.1:8080", "127.0.0.1:8081", "127.0.0.1:8082"})
+	assert.ElementsMatch(t, addrs[3:], []string{"127.0.0.1:8080", "127.0.0.1:8081", "127.0.0.1:8082"})
+	for _, done := range dones {
+		done(context.Background(), selector.DoneInfo{})
+	}
+}
+
+func TestWeight(t *testing.T) {
+	s := powerofd.New(powerofd.WithChoices(3), powerofd.WithNodeBuilder(&direct.Builder{}))
+	s.Apply([]selector.Node{
+		selector.NewNode("http", "127.0.0.1:8080", selector.RawMetadata("weight", "10")),
+		selector.NewNode("http", "127.0.0.1:8081", selector.RawMetadata("weight", "30")),
+		selector.NewNode("http", "127.0.0.1:8082", selector.RawMetadata("weight", "20")),
+	})
+	for i := 0; i < 10; i++ {
+		n, done, err := s.Select(context.Background())
+		assert.NoError(t, err)
+		done(context.Background(), selector.DoneInfo{})
+		assert.Equal(t, "127.0.0.1:8081", n.Address())
+	}
+}
@@ -3,6 +3,7 @@ package random
 import (
 	"context"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/omalloc/proxy/selector"
@@ -16,15 +17,56 @@ const (
 
 var _ selector.Balancer = (*Balancer)(nil) // Name is balancer name
 
+func init() {
+	selector.Register(Name, NewBuilder())
+}
+
 // Option is random builder option.
 type Option func(o *options)
 
 // options is random builder options
-type options struct{}
+type options struct {
+	node     selector.WeightedNodeBuilder
+	source   rand.Source
+	weighted bool
+	refresh  time.Duration
+}
+
+// WithWeighted pick nodes with the probability of their weights instead of uniformly.
+// The weights are cached for the refresh interval, default is 100ms.
+func WithWeighted(refresh time.Duration) Option {
+	return func(o *options) {
+		o.weighted = true
+		o.refresh = refresh
+	}
+}
+
+// WithNodeBuilder set the weighted node builder, default is the direct node builder.
+func WithNodeBuilder(b selector.WeightedNodeBuilder) Option {
+	return func(o *options) {
+		o.node = b
+	}
+}
+
+// WithSource set the random source, default is seeded by the current time.
+func WithSource(src rand.Source) Option {
+	return func(o *options) {
+		o.source = src
+	}
+}
+
+// WithSeed set the seed of the random source, for deterministic picks in tests.
+func WithSeed(seed int64) Option {
+	return WithSource(rand.NewSource(seed))
+}
 
 // Balancer is a random balancer.
 type Balancer struct {
-	random *rand.Rand
+	mu       sync.Mutex
+	random   *rand.Rand
+	weighted bool
+	refresh  time.Duration
+	sums     prefixSums
 }
 
 // New a random selector.
@@ -32,12 +74,24 @@ func New(opts ...Option) selector.Selector {
 	return NewBuilder(opts...).Build()
 }
 
+// Name is balancer name
+func (p *Balancer) Name() string {
+	return Name
+}
+
 // Pick is pick a weighted node.
 func (p *Balancer) Pick(_ context.Context, nodes []selector.WeightedNode) (selector.WeightedNode, selector.DoneFunc, error) {
 	if len(nodes) == 0 {
 		return nil, nil, selector.ErrNoAvailable
 	}
-	cur := p.random.Intn(len(nodes))
+	p.mu.Lock()
+	var cur int
+	if p.weighted {
+		cur = p.pickWeighted(nodes)
+	} else {
+		cur = p.random.Intn(len(nodes))
+	}
+	p.mu.Unlock()
 	selected := nodes[cur]
 	d := selected.Pick()
 	return selected, d, nil
@@ -49,18 +103,40 @@ func NewBuilder(opts ...Option) selector.Builder {
 	for _, opt := range opts {
 		opt(&option)
 	}
+	node := option.node
+	if node == nil {
+		node = &direct.Builder{}
+	}
 	return &selector.DefaultBuilder{
-		Balancer: &Builder{},
-		Node:     &direct.Builder{},
+		Balancer: &Builder{
+			source:   option.source,
+			weighted: option.weighted,
+			refresh:  option.refresh,
+		},
+		Node: node,
 	}
 }
 
 // Builder is random builder
-type Builder struct{}
+type Builder struct {
+	source   rand.Source
+	weighted bool
+	refresh  time.Duration
+}
 
 // Build creates Balancer
 func (b *Builder) Build() selector.Balancer {
+	src := b.source
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	refresh := b.refresh
+	if refresh <= 0 {
+		refresh = defaultRefresh
+	}
 	return &Balancer{
-		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+		random:   rand.New(src),
+		weighted: b.weighted,
+		refresh:  refresh,
 	}
 }
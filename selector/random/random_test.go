@@ -0,0 +1,84 @@
+package random_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/node/direct"
+	"github.com/omalloc/proxy/selector/random"
+)
+
+// weightedNode is a node of a fixed weight.
+type weightedNode struct {
+	selector.WeightedNode
+	weight float64
+}
+
+func (n *weightedNode) Weight() float64 {
+	return n.weight
+}
+
+func TestSeed(t *testing.T) {
+	nodes := []selector.Node{
+		selector.NewNode("http", "127.0.0.1:8080", nil),
+		selector.NewNode("http", "127.0.0.1:8081", nil),
+		selector.NewNode("http", "127.0.0.1:8082", nil),
+		selector.NewNode("http", "127.0.0.1:8083", nil),
+	}
+	var picks [2][]string
+	for i := range picks {
+		s := random.New(random.WithSeed(1))
+		s.Apply(nodes)
+		for j := 0; j < 20; j++ {
+			n, done, err := s.Select(context.Background())
+			assert.NoError(t, err)
+			done(context.Background(), selector.DoneInfo{})
+			picks[i] = append(picks[i], n.Address())
+		}
+	}
+	assert.Equal(t, picks[0], picks[1])
+}
+
+func TestWeighted(t *testing.T) {
+	s := random.New(random.WithWeighted(0), random.WithSeed(1))
+	s.Apply([]selector.Node{
+		selector.NewNode("http", "127.0.0.1:8080", selector.RawMetadata("weight", "10")),
+		selector.NewNode("http", "127.0.0.1:8081", selector.RawMetadata("weight", "1000")),
+		selector.NewNode("http", "127.0.0.1:8082", selector.RawMetadata("weight", "0")),
+	})
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		n, done, err := s.Select(context.Background())
+		assert.NoError(t, err)
+		done(context.Background(), selector.DoneInfo{})
+		counts[n.Address()]++
+	}
+	assert.InDelta(t, 99, counts["127.0.0.1:8080"], 40)
+	assert.Zero(t, counts["127.0.0.1:8082"])
+}
+
+func TestWeightedNonFinite(t *testing.T) {
+	for _, weights := range [][]float64{
+		{math.Inf(1), 1},
+		{1, math.Inf(1)},
+		{math.NaN(), -1},
+		{math.MaxFloat64, math.MaxFloat64},
+	} {
+		bal := random.NewBuilder(random.WithWeighted(0), random.WithSeed(1)).(*selector.DefaultBuilder).Balancer.Build()
+		var nodes []selector.WeightedNode
+		for _, w := range weights {
+			n := (&direct.Builder{}).Build(selector.NewNode("http", "127.0.0.1:8080", nil))
+			nodes = append(nodes, &weightedNode{WeightedNode: n, weight: w})
+		}
+		for i := 0; i < 100; i++ {
+			n, done, err := bal.Pick(context.Background(), nodes)
+			assert.NoError(t, err)
+			assert.Contains(t, nodes, n)
+			done(context.Background(), selector.DoneInfo{})
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package random
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+)
+
+// defaultRefresh is the interval the cached weights are refreshed at.
+const defaultRefresh = 100 * time.Millisecond
+
+// prefixSums is the cumulative weights of nodes.
+type prefixSums struct {
+	nodes []selector.WeightedNode
+	sums  []float64
+	at    time.Time
+}
+
+// valid reports whether the sums are built from nodes within refresh.
+// The applied nodes are passed as the same slice until the next apply,
+// filtered nodes are a new slice on every pick.
+func (ps *prefixSums) valid(nodes []selector.WeightedNode, refresh time.Duration) bool {
+	return len(ps.nodes) == len(nodes) && len(nodes) > 0 &&
+		&ps.nodes[0] == &nodes[0] && time.Since(ps.at) < refresh
+}
+
+func (ps *prefixSums) build(nodes []selector.WeightedNode) {
+	ps.nodes = nodes
+	ps.sums = ps.sums[:0]
+	ps.at = time.Now()
+
+	var total float64
+	for _, n := range nodes {
+		// the non-finite weights are skipped, they would make the total infinite
+		if w := n.Weight(); w > 0 && !math.IsInf(w, 0) {
+			total += w
+		}
+		ps.sums = append(ps.sums, total)
+	}
+}
+
+// search returns the index of the node owning x in [0, total).
+func (ps *prefixSums) search(x float64) int {
+	i := sort.Search(len(ps.sums), func(i int) bool { return ps.sums[i] > x })
+	return min(i, len(ps.sums)-1)
+}
+
+func (ps *prefixSums) total() float64 {
+	if len(ps.sums) == 0 {
+		return 0
+	}
+	return ps.sums[len(ps.sums)-1]
+}
+
+// pickWeighted returns the index of a node picked with the probability of its weight,
+// uniformly if all weights are zero or they overflow. It must be called with p.mu held.
+func (p *Balancer) pickWeighted(nodes []selector.WeightedNode) int {
+	if !p.sums.valid(nodes, p.refresh) {
+		p.sums.build(nodes)
+	}
+	total := p.sums.total()
+	if total <= 0 || math.IsInf(total, 0) {
+		return p.random.Intn(len(nodes))
+	}
+	return p.sums.search(p.random.Float64() * total)
+}
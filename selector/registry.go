@@ -0,0 +1,26 @@
+package selector
+
+import "sync"
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Builder)
+)
+
+// Register makes a selector builder available by name, e.g. the balancer packages register
+// their default builder on import. A later registration of the same name replaces it.
+func Register(name string, b Builder) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[name] = b
+}
+
+// Lookup returns the selector builder registered by name.
+func Lookup(name string) (Builder, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	b, ok := registry[name]
+	return b, ok
+}
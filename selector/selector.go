@@ -1,3 +1,7 @@
+// Package selector picks the node of a request by a balancer over weighted nodes.
+//
+// The random source given to a balancer by its WithSource option is used under the lock of
+// that balancer only, so it must not be shared with other balancers or used elsewhere.
 package selector
 
 import (
@@ -5,13 +9,22 @@ import (
 	"errors"
 	"strconv"
 	"sync/atomic"
+	"time"
 )
 
-var _ Rebalancer = (*defaultSelector)(nil)
+var (
+	_ Rebalancer = (*defaultSelector)(nil)
+	_ NodeLister = (*defaultSelector)(nil)
+	_ Observable = (*defaultSelector)(nil)
+)
 
 // ErrNoAvailable is no available node.
 var ErrNoAvailable = errors.New("no_available_node")
 
+// ErrDiscarded is reported in DoneInfo.Err when the result of a pick is no sample of
+// the node, e.g. the request is never sent. Nodes release the pick without recording it.
+var ErrDiscarded = errors.New("discarded")
+
 // Selector is node pick balancer.
 type Selector interface {
 	Rebalancer
@@ -27,6 +40,16 @@ type Rebalancer interface {
 	Apply(nodes []Node)
 }
 
+// NodeLister lists the weighted nodes currently applied.
+type NodeLister interface {
+	Nodes() []WeightedNode
+}
+
+// Namer is implemented by selectors and balancers which have a name.
+type Namer interface {
+	Name() string
+}
+
 // Builder build selector
 type Builder interface {
 	Build() Selector
@@ -37,13 +60,14 @@ type defaultSelector struct {
 	NodeBuilder WeightedNodeBuilder
 	Balancer    Balancer
 
-	nodes atomic.Value
+	nodes     atomic.Value
+	observers Observers
 }
 
 func (d *defaultSelector) Select(ctx context.Context, opts ...SelectOption) (selected Node, done DoneFunc, err error) {
 	// 快速继承上下文中的 peer 直接执行返回
 	if p, ok := FromPeerContext(ctx); ok {
-		return p.Node, donef, nil
+		return p.Node, d.observe(ctx, p.Node, donef), nil
 	}
 
 	// 正常执行选节点
@@ -54,6 +78,7 @@ func (d *defaultSelector) Select(ctx context.Context, opts ...SelectOption) (sel
 
 	nodes, ok := d.nodes.Load().([]WeightedNode)
 	if !ok {
+		d.observers.NotifyNoAvailable(ctx)
 		return nil, nil, ErrNoAvailable
 	}
 
@@ -77,11 +102,15 @@ func (d *defaultSelector) Select(ctx context.Context, opts ...SelectOption) (sel
 	}
 
 	if len(candidates) == 0 {
+		d.observers.NotifyNoAvailable(ctx)
 		return nil, nil, ErrNoAvailable
 	}
 
 	wn, done, err := d.Balancer.Pick(ctx, candidates)
 	if err != nil {
+		if errors.Is(err, ErrNoAvailable) {
+			d.observers.NotifyNoAvailable(ctx)
+		}
 		return nil, nil, err
 	}
 
@@ -90,7 +119,20 @@ func (d *defaultSelector) Select(ctx context.Context, opts ...SelectOption) (sel
 		p.Node = wn.Raw()
 	}
 
-	return wn.Raw(), done, nil
+	return wn.Raw(), d.observe(ctx, wn.Raw(), done), nil
+}
+
+// observe notifies the selection and wraps done to notify the completion.
+func (d *defaultSelector) observe(ctx context.Context, node Node, done DoneFunc) DoneFunc {
+	if d.observers.Len() == 0 {
+		return done
+	}
+	d.observers.NotifySelect(ctx, node)
+	start := time.Now()
+	return func(ctx context.Context, di DoneInfo) {
+		done(ctx, di)
+		d.observers.NotifyDone(ctx, node, di, time.Since(start))
+	}
 }
 
 func (d *defaultSelector) Apply(nodes []Node) {
@@ -98,8 +140,37 @@ func (d *defaultSelector) Apply(nodes []Node) {
 	for _, n := range nodes {
 		weightedNodes = append(weightedNodes, d.NodeBuilder.Build(n))
 	}
+	if r, ok := d.Balancer.(BalancerRebalancer); ok {
+		r.Apply(weightedNodes)
+	}
 	// TODO: Do not delete unchanged nodes
-	d.nodes.Store(weightedNodes)
+	old, _ := d.nodes.Swap(weightedNodes).([]WeightedNode)
+	if d.observers.Len() > 0 {
+		oldNodes := make([]Node, len(old))
+		for i, wn := range old {
+			oldNodes[i] = wn.Raw()
+		}
+		d.observers.NotifyApply(oldNodes, nodes)
+	}
+}
+
+// Observe register an observer on selection, completion and pool changes.
+func (d *defaultSelector) Observe(o Observer) {
+	d.observers.Add(o)
+}
+
+// Name returns the balancer name, empty if unknown.
+func (d *defaultSelector) Name() string {
+	if n, ok := d.Balancer.(Namer); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+// Nodes returns the applied weighted nodes.
+func (d *defaultSelector) Nodes() []WeightedNode {
+	nodes, _ := d.nodes.Load().([]WeightedNode)
+	return nodes
 }
 
 // DefaultBuilder is de
@@ -2,8 +2,15 @@ package selector_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 
 	"github.com/omalloc/proxy/selector"
 	"github.com/omalloc/proxy/selector/node/direct"
@@ -76,3 +83,60 @@ func TestSelectNodeWithP2C(t *testing.T) {
 		done(context.Background(), selector.DoneInfo{})
 	}
 }
+
+func TestSelectorObserver(t *testing.T) {
+	var selected, done, noAvailable int64
+	var applied [][]selector.Node
+
+	def := random.NewBuilder().Build()
+	def.(selector.Observable).Observe(selector.Observer{
+		OnSelect: func(ctx context.Context, node selector.Node) {
+			atomic.AddInt64(&selected, 1)
+		},
+		OnDone: func(ctx context.Context, node selector.Node, di selector.DoneInfo, latency time.Duration) {
+			atomic.AddInt64(&done, 1)
+		},
+		OnApply: func(old, new []selector.Node) {
+			applied = append(applied, old, new)
+		},
+		OnNoAvailable: func(ctx context.Context) {
+			atomic.AddInt64(&noAvailable, 1)
+		},
+	})
+
+	_, _, err := def.Select(context.Background())
+	assert.Equal(t, selector.ErrNoAvailable, err)
+
+	nodes := []selector.Node{selector.NewNode("http", "127.0.0.1:8280", nil)}
+	def.Apply(nodes)
+	assert.Equal(t, [][]selector.Node{{}, nodes}, applied)
+
+	for i := 0; i < 10; i++ {
+		_, d, err := def.Select(context.Background())
+		assert.NoError(t, err)
+		d(context.Background(), selector.DoneInfo{})
+	}
+
+	assert.Equal(t, int64(10), selected)
+	assert.Equal(t, int64(10), done)
+	assert.Equal(t, int64(1), noAvailable)
+}
+
+func TestErrorClass(t *testing.T) {
+	for _, tt := range []struct {
+		err    error
+		status int
+		class  string
+	}{
+		{nil, http.StatusOK, ""},
+		{nil, http.StatusTooManyRequests, selector.ClassThrottled},
+		{nil, http.StatusBadGateway, selector.ClassServer},
+		{fmt.Errorf("pick: %w", selector.ErrNoAvailable), 0, selector.ClassNoAvailable},
+		{context.Canceled, 0, selector.ClassCanceled},
+		{context.DeadlineExceeded, 0, selector.ClassTimeout},
+		{&net.OpError{Op: "dial", Err: errors.New("refused")}, 0, selector.ClassNetwork},
+		{errors.New("boom"), http.StatusOK, selector.ClassUnknown},
+	} {
+		assert.Equal(t, tt.class, selector.ErrorClass(tt.err, tt.status), "%v %d", tt.err, tt.status)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 )
 
+var _ NodeLister = (*staticSelector)(nil)
+
 // staticSelector is composite selector.
 type staticSelector struct {
 	NodeBuilder WeightedNodeBuilder
@@ -18,13 +20,36 @@ func (d *staticSelector) Select(ctx context.Context, opts ...SelectOption) (sele
 		return p.Node, donef, nil
 	}
 
+	if d.node == nil {
+		return nil, nil, ErrNoAvailable
+	}
 	return d.node, donef, nil
 }
 
 func (d *staticSelector) Apply(nodes []Node) {
+	if len(nodes) == 0 {
+		d.node = nil
+		return
+	}
 	d.node = d.NodeBuilder.Build(nodes[0])
 }
 
+// Name returns the balancer name, empty if unknown.
+func (d *staticSelector) Name() string {
+	if n, ok := d.Balancer.(Namer); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+// Nodes returns the applied weighted node.
+func (d *staticSelector) Nodes() []WeightedNode {
+	if d.node == nil {
+		return nil
+	}
+	return []WeightedNode{d.node}
+}
+
 // StaticNodeBuilder is de
 type StaticNodeBuilder struct {
 	Node     WeightedNodeBuilder
@@ -0,0 +1,160 @@
+package subset
+
+import (
+	"hash/fnv"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/internal/rnd"
+)
+
+const (
+	// defaultSize is the default number of nodes in the subset.
+	defaultSize = 20
+)
+
+var _ selector.Rebalancer = (*Subset)(nil)
+
+// Option is subset option.
+type Option func(o *options)
+
+// options is subset options
+type options struct {
+	id    string
+	index int
+	size  int
+}
+
+// WithIndex set the index of this client instance among all the clients, e.g. the ordinal
+// of a stateful set. The clients of consecutive indexes cover all nodes evenly.
+func WithIndex(index int) Option {
+	return func(o *options) {
+		o.index = index
+	}
+}
+
+// WithID set the id of this client instance the index is hashed from if WithIndex isn't set,
+// default is the hostname. Hashed indexes collide, so the coverage of the nodes isn't even.
+func WithID(id string) Option {
+	return func(o *options) {
+		o.id = id
+	}
+}
+
+// WithSize set the number of nodes in the subset, default is 20.
+func WithSize(k int) Option {
+	return func(o *options) {
+		o.size = k
+	}
+}
+
+// Subset applies a deterministic subset of the nodes to target.
+//
+// The nodes are ordered by a score of their address in the round of the client, i.e. of
+// len(nodes)/k consecutive indexes rounded up, and the clients of a round take consecutive
+// windows of k nodes of the order, the last one wrapping around. So each node is taken by
+// the same number of clients, give or take one. A node leaving or joining moves at most one
+// node out of a subset, unless the number of subsets of a round changes.
+type Subset struct {
+	mu     sync.Mutex
+	target selector.Rebalancer
+	opts   options
+	nodes  []selector.Node
+}
+
+// New create a subset in front of target.
+func New(target selector.Rebalancer, opts ...Option) *Subset {
+	s := &Subset{
+		target: target,
+		opts: options{
+			index: -1,
+			size:  defaultSize,
+		},
+	}
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+	if s.opts.index < 0 {
+		if s.opts.id == "" {
+			s.opts.id, _ = os.Hostname()
+		}
+		s.opts.index = hashIndex(s.opts.id)
+	}
+	return s
+}
+
+// Apply is apply the subset of nodes when any changes happen.
+func (s *Subset) Apply(nodes []selector.Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nodes = Choose(s.opts.index, s.opts.size, nodes)
+	s.target.Apply(s.nodes)
+}
+
+// Nodes returns the applied subset.
+func (s *Subset) Nodes() []selector.Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.nodes
+}
+
+// Choose returns the subset of k nodes of the client index, regardless of the order of nodes.
+// All nodes are returned if there are no more than k.
+func Choose(index, k int, nodes []selector.Node) []selector.Node {
+	if k <= 0 || len(nodes) <= k {
+		return nodes
+	}
+	if index < 0 {
+		index = -index
+	}
+
+	count := (len(nodes) + k - 1) / k
+	round := uint64(index / count)
+	ordered := order(nodes, round)
+
+	start := (index % count) * k
+	subset := make([]selector.Node, 0, k)
+	for i := 0; i < k; i++ {
+		subset = append(subset, ordered[(start+i)%len(ordered)])
+	}
+	sort.Slice(subset, func(i, j int) bool { return subset[i].Address() < subset[j].Address() })
+	return subset
+}
+
+// order returns the nodes ordered by their scores in the round. The score is keyed on the
+// address rather than the position, so that a node leaving or joining shifts the others by one.
+func order(nodes []selector.Node, round uint64) []selector.Node {
+	type scored struct {
+		node  selector.Node
+		score uint64
+	}
+	seed := rnd.Mix(round)
+	scores := make([]scored, len(nodes))
+	for i, n := range nodes {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(n.Address()))
+		scores[i] = scored{node: n, score: rnd.Mix(h.Sum64() ^ seed)}
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].score != scores[j].score {
+			return scores[i].score < scores[j].score
+		}
+		return scores[i].node.Address() < scores[j].node.Address()
+	})
+	ordered := make([]selector.Node, len(nodes))
+	for i, s := range scores {
+		ordered[i] = s.node
+	}
+	return ordered
+}
+
+// hashIndex returns the index of the client id.
+func hashIndex(id string) int {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(id))
+	return int(rnd.Mix(h.Sum64()) >> 33)
+}
@@ -0,0 +1,108 @@
+package subset_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/random"
+	"github.com/omalloc/proxy/selector/subset"
+)
+
+func TestSubset(t *testing.T) {
+	var nodes, reversed []selector.Node
+	for i := 0; i < 100; i++ {
+		nodes = append(nodes, selector.NewNode("http", fmt.Sprintf("10.0.0.%d:80", i), nil))
+	}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		reversed = append(reversed, nodes[i])
+	}
+
+	target := random.New()
+	s := subset.New(target, subset.WithIndex(3), subset.WithSize(10))
+	s.Apply(nodes)
+	assert.Len(t, s.Nodes(), 10)
+	assert.Len(t, target.(selector.NodeLister).Nodes(), 10)
+
+	// deterministic regardless of the order
+	assert.Equal(t, s.Nodes(), subset.Choose(3, 10, reversed))
+
+	// the index is hashed from the id if not set
+	s = subset.New(target, subset.WithID("client-1"), subset.WithSize(10))
+	s.Apply(nodes)
+	assert.Len(t, s.Nodes(), 10)
+	other := subset.New(random.New(), subset.WithID("client-1"), subset.WithSize(10))
+	other.Apply(reversed)
+	assert.Equal(t, s.Nodes(), other.Nodes())
+
+	// fewer nodes than the size are all applied
+	s.Apply(nodes[:5])
+	assert.Len(t, s.Nodes(), 5)
+}
+
+func TestSubsetCoverage(t *testing.T) {
+	for _, tt := range []struct {
+		clients, nodes, size int
+	}{
+		{10, 100, 10},
+		{20, 100, 10},
+		{12, 50, 10},
+		{7, 30, 4},
+	} {
+		var nodes []selector.Node
+		for i := 0; i < tt.nodes; i++ {
+			nodes = append(nodes, selector.NewNode("http", fmt.Sprintf("10.0.0.%d:80", i), nil))
+		}
+		counts := make(map[string]int)
+		for i := 0; i < tt.clients; i++ {
+			chosen := subset.Choose(i, tt.size, nodes)
+			distinct := make(map[string]bool)
+			for _, n := range chosen {
+				distinct[n.Address()] = true
+				counts[n.Address()]++
+			}
+			assert.Len(t, distinct, tt.size)
+		}
+		// the clients of a round take each node at most once
+		total := tt.clients * tt.size
+		covered := min(total, tt.nodes/tt.size*tt.size)
+		assert.GreaterOrEqual(t, len(counts), covered, "%+v", tt)
+		rounds := (tt.clients + tt.nodes/tt.size - 1) / (tt.nodes / tt.size)
+		for addr, c := range counts {
+			assert.LessOrEqual(t, c, rounds, "%+v %s", tt, addr)
+		}
+	}
+}
+
+func TestSubsetChurn(t *testing.T) {
+	var nodes []selector.Node
+	for i := 0; i < 200; i++ {
+		nodes = append(nodes, selector.NewNode("http", fmt.Sprintf("10.0.1.%d:80", i), nil))
+	}
+	removed := append(append([]selector.Node{}, nodes[:57]...), nodes[58:]...)
+	replaced := append(append([]selector.Node{}, removed...), selector.NewNode("http", "10.0.2.1:80", nil))
+	for _, tt := range []struct {
+		name  string
+		nodes []selector.Node
+		kept  int
+	}{
+		{"removed", removed, 19},
+		{"replaced", replaced, 18},
+	} {
+		for i := 0; i < 40; i++ {
+			before := make(map[string]bool)
+			for _, n := range subset.Choose(i, 20, nodes) {
+				before[n.Address()] = true
+			}
+			kept := 0
+			for _, n := range subset.Choose(i, 20, tt.nodes) {
+				if before[n.Address()] {
+					kept++
+				}
+			}
+			assert.GreaterOrEqual(t, kept, tt.kept, "%s client %d", tt.name, i)
+		}
+	}
+}
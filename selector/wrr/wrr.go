@@ -2,7 +2,9 @@ package wrr
 
 import (
 	"context"
+	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/omalloc/proxy/selector"
 	"github.com/omalloc/proxy/selector/node/direct"
@@ -15,16 +17,64 @@ const (
 
 var _ selector.Balancer = (*Balancer)(nil) // Name is balancer name
 
+func init() {
+	selector.Register(Name, NewBuilder())
+}
+
 // Option is wrr builder option.
 type Option func(o *options)
 
+// TieBreak is how the nodes of the same current weight are picked.
+type TieBreak int
+
+const (
+	// TieBreakFirst picks the first node in order.
+	TieBreakFirst TieBreak = iota
+	// TieBreakRandom picks a random node.
+	TieBreakRandom
+	// TieBreakAddress picks the node of the lowest address, regardless of the order.
+	TieBreakAddress
+)
+
 // options is wrr builder options
-type options struct{}
+type options struct {
+	node     selector.WeightedNodeBuilder
+	tieBreak TieBreak
+	source   rand.Source
+}
+
+// WithNodeBuilder set the weighted node builder, default is the direct node builder.
+func WithNodeBuilder(b selector.WeightedNodeBuilder) Option {
+	return func(o *options) {
+		o.node = b
+	}
+}
+
+// WithTieBreak set how ties are broken, default is TieBreakFirst.
+func WithTieBreak(t TieBreak) Option {
+	return func(o *options) {
+		o.tieBreak = t
+	}
+}
+
+// WithSource set the random source of TieBreakRandom, default is seeded by the current time.
+func WithSource(src rand.Source) Option {
+	return func(o *options) {
+		o.source = src
+	}
+}
+
+// WithSeed set the seed of the random source, for deterministic picks in tests.
+func WithSeed(seed int64) Option {
+	return WithSource(rand.NewSource(seed))
+}
 
 // Balancer is a wrr balancer.
 type Balancer struct {
 	mu            sync.Mutex
 	currentWeight map[string]float64
+	tieBreak      TieBreak
+	random        *rand.Rand
 }
 
 // New random a selector.
@@ -32,6 +82,11 @@ func New(opts ...Option) selector.Selector {
 	return NewBuilder(opts...).Build()
 }
 
+// Name is balancer name
+func (p *Balancer) Name() string {
+	return Name
+}
+
 // Pick is pick a weighted node.
 func (p *Balancer) Pick(_ context.Context, nodes []selector.WeightedNode) (selector.WeightedNode, selector.DoneFunc, error) {
 	if len(nodes) == 0 {
@@ -40,6 +95,7 @@ func (p *Balancer) Pick(_ context.Context, nodes []selector.WeightedNode) (selec
 	var totalWeight float64
 	var selected selector.WeightedNode
 	var selectWeight float64
+	var ties int
 
 	// nginx wrr load balancing algorithm: http://blog.csdn.net/zhangskd/article/details/50194069
 	p.mu.Lock()
@@ -52,6 +108,9 @@ func (p *Balancer) Pick(_ context.Context, nodes []selector.WeightedNode) (selec
 		if selected == nil || selectWeight < cwt {
 			selectWeight = cwt
 			selected = node
+			ties = 1
+		} else if selectWeight == cwt && p.breakTie(selected, node, &ties) {
+			selected = node
 		}
 	}
 	p.currentWeight[selected.Address()] = selectWeight - totalWeight
@@ -61,22 +120,51 @@ func (p *Balancer) Pick(_ context.Context, nodes []selector.WeightedNode) (selec
 	return selected, d, nil
 }
 
+// breakTie reports whether node is picked over selected of the same current weight,
+// ties is the number of tied nodes so far, must be called with p.mu held.
+func (p *Balancer) breakTie(selected, node selector.WeightedNode, ties *int) bool {
+	switch p.tieBreak {
+	case TieBreakRandom:
+		// reservoir sampling keeps each tied node with the same probability
+		*ties++
+		return p.random.Intn(*ties) == 0
+	case TieBreakAddress:
+		return node.Address() < selected.Address()
+	}
+	return false
+}
+
 // NewBuilder returns a selector builder with wrr balancer
 func NewBuilder(opts ...Option) selector.Builder {
 	var option options
 	for _, opt := range opts {
 		opt(&option)
 	}
+	node := option.node
+	if node == nil {
+		node = &direct.Builder{}
+	}
 	return &selector.DefaultBuilder{
-		Balancer: &Builder{},
-		Node:     &direct.Builder{},
+		Balancer: &Builder{tieBreak: option.tieBreak, source: option.source},
+		Node:     node,
 	}
 }
 
 // Builder is wrr builder
-type Builder struct{}
+type Builder struct {
+	tieBreak TieBreak
+	source   rand.Source
+}
 
 // Build creates Balancer
 func (b *Builder) Build() selector.Balancer {
-	return &Balancer{currentWeight: make(map[string]float64)}
+	src := b.source
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	return &Balancer{
+		currentWeight: make(map[string]float64),
+		tieBreak:      b.tieBreak,
+		random:        rand.New(src),
+	}
 }
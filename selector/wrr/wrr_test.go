@@ -0,0 +1,43 @@
+package wrr_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/wrr"
+)
+
+func TestTieBreak(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		opts   []wrr.Option
+		want   []string
+		sorted bool
+	}{
+		{"address", []wrr.Option{wrr.WithTieBreak(wrr.TieBreakAddress)}, []string{"127.0.0.1:8080", "127.0.0.1:8081", "127.0.0.1:8082"}, true},
+		{"applied", nil, []string{"127.0.0.1:8082", "127.0.0.1:8080", "127.0.0.1:8081"}, true},
+		{"random", []wrr.Option{wrr.WithTieBreak(wrr.TieBreakRandom), wrr.WithSeed(1)}, []string{"127.0.0.1:8080", "127.0.0.1:8081", "127.0.0.1:8082"}, false},
+	} {
+		s := wrr.New(tt.opts...)
+		s.Apply([]selector.Node{
+			selector.NewNode("http", "127.0.0.1:8082", nil),
+			selector.NewNode("http", "127.0.0.1:8080", nil),
+			selector.NewNode("http", "127.0.0.1:8081", nil),
+		})
+		var addrs []string
+		for i := 0; i < 3; i++ {
+			n, done, err := s.Select(context.Background())
+			assert.NoError(t, err)
+			done(context.Background(), selector.DoneInfo{})
+			addrs = append(addrs, n.Address())
+		}
+		if tt.sorted {
+			assert.Equal(t, tt.want, addrs, tt.name)
+		} else {
+			assert.ElementsMatch(t, tt.want, addrs, tt.name)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrShed is returned when a request is shed because the pool is saturated.
+var ErrShed = errors.New("load_shed")
+
+// Priority is the priority class of a request, lower priorities are shed first.
+type Priority int
+
+const (
+	PriorityBackground Priority = iota
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+	// PriorityCritical requests are never shed.
+	PriorityCritical
+)
+
+var priorityNames = []string{"background", "low", "normal", "high", "critical"}
+
+func (p Priority) String() string {
+	if p >= 0 && int(p) < len(priorityNames) {
+		return priorityNames[p]
+	}
+	return strconv.Itoa(int(p))
+}
+
+// ParsePriority parses a priority by name or number.
+func ParsePriority(s string) (Priority, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, name := range priorityNames {
+		if s == name {
+			return Priority(i), true
+		}
+	}
+	if v, err := strconv.Atoi(s); err == nil && v >= int(PriorityBackground) && v <= int(PriorityCritical) {
+		return Priority(v), true
+	}
+	return PriorityNormal, false
+}
+
+type priorityKey struct{}
+
+// NewPriorityContext creates a new context with the request priority attached.
+func NewPriorityContext(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// FromPriorityContext returns the request priority in ctx if it exists.
+func FromPriorityContext(ctx context.Context) (p Priority, ok bool) {
+	p, ok = ctx.Value(priorityKey{}).(Priority)
+	return
+}
+
+// ShedThreshold is the load at which requests of a priority are shed.
+type ShedThreshold struct {
+	Priority Priority
+	// InflightRatio sheds when the requests in flight reach the ratio of the capacity, 0 disables it.
+	InflightRatio float64
+	// QueueDepth sheds when the queued requests reach the depth, 0 disables it.
+	QueueDepth int
+}
+
+// defaultShedThresholds sheds background traffic first and critical traffic never.
+var defaultShedThresholds = []ShedThreshold{
+	{Priority: PriorityBackground, InflightRatio: 0.5, QueueDepth: 1},
+	{Priority: PriorityLow, InflightRatio: 0.75},
+	{Priority: PriorityNormal, InflightRatio: 0.9},
+	{Priority: PriorityHigh, InflightRatio: 1},
+}
+
+// shedding rejects requests by priority when the pool is saturated.
+type shedding struct {
+	capacity   int
+	thresholds map[Priority]ShedThreshold
+	inflight   int64
+}
+
+func newShedding(capacity int, thresholds []ShedThreshold) *shedding {
+	if len(thresholds) == 0 {
+		thresholds = defaultShedThresholds
+	}
+	s := &shedding{
+		capacity:   capacity,
+		thresholds: make(map[Priority]ShedThreshold, len(thresholds)),
+	}
+	for _, t := range thresholds {
+		s.thresholds[t.Priority] = t
+	}
+	return s
+}
+
+// shed reports whether a request of priority p is shed under the current load.
+func (s *shedding) shed(p Priority, capacity, queued int) bool {
+	if p >= PriorityCritical {
+		return false
+	}
+	t, ok := s.thresholds[p]
+	if !ok {
+		return false
+	}
+	if t.QueueDepth > 0 && queued >= t.QueueDepth {
+		return true
+	}
+	if t.InflightRatio > 0 && capacity > 0 {
+		return float64(atomic.LoadInt64(&s.inflight)) >= t.InflightRatio*float64(capacity)
+	}
+	return false
+}
+
+// admit takes an in-flight slot of req, or fails with ErrShed.
+// The returned func releases the slot.
+func (r *ReverseProxy) admit(req *http.Request) (func(), error) {
+	if r.shedding == nil {
+		return func() {}, nil
+	}
+
+	capacity := r.shedding.capacity
+	if capacity <= 0 {
+		// learned by the adaptive concurrency limiter
+		capacity = r.limiter.Limit()
+	}
+	queued := r.limiter.Queued()
+	if r.conns != nil {
+		queued += r.conns.depth()
+	}
+	if r.shedding.shed(r.priorityOf(req), capacity, queued) {
+		return nil, ErrShed
+	}
+
+	atomic.AddInt64(&r.shedding.inflight, 1)
+	return func() {
+		atomic.AddInt64(&r.shedding.inflight, -1)
+	}, nil
+}
+
+// priorityOf returns the priority of req from its context, then its header.
+func (r *ReverseProxy) priorityOf(req *http.Request) Priority {
+	if p, ok := FromPriorityContext(req.Context()); ok {
+		return p
+	}
+	if r.priorityHeader != "" {
+		if p, ok := ParsePriority(req.Header.Get(r.priorityHeader)); ok {
+			return p
+		}
+	}
+	return PriorityNormal
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/limiter"
+	"github.com/omalloc/proxy/selector"
+)
+
+func TestParsePriority(t *testing.T) {
+	p, ok := ParsePriority(" High ")
+	assert.True(t, ok)
+	assert.Equal(t, PriorityHigh, p)
+
+	p, ok = ParsePriority("0")
+	assert.True(t, ok)
+	assert.Equal(t, PriorityBackground, p)
+
+	_, ok = ParsePriority("9")
+	assert.False(t, ok)
+	assert.Equal(t, "critical", PriorityCritical.String())
+}
+
+func TestLoadShedding(t *testing.T) {
+	var inflight int64
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&inflight, 1)
+		<-release
+	}))
+	defer ts.Close()
+
+	p := New(
+		WithInitialNodes([]selector.Node{selector.NewNode("http", ts.URL[7:], nil)}),
+		WithLoadShedding(2),
+		WithPriorityHeader("X-Priority"),
+	)
+
+	do := func(ctx context.Context, priority string) error {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+		if priority != "" {
+			req.Header.Set("X-Priority", priority)
+		}
+		resp, err := p.Do(req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- do(context.Background(), "") }()
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&inflight) == 1 }, time.Second, time.Millisecond)
+
+	// one of two in flight reaches the background threshold only
+	err := do(context.Background(), "background")
+	assert.True(t, errors.Is(err, ErrShed))
+	assert.Equal(t, ClassShed, ErrorClass(err, 0))
+
+	// the priority in context takes precedence over the header
+	ctx := NewPriorityContext(context.Background(), PriorityCritical)
+	go func() { errs <- do(ctx, "background") }()
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&inflight) == 2 }, time.Second, time.Millisecond)
+
+	// critical requests are never shed
+	go func() { errs <- do(ctx, "") }()
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&inflight) == 3 }, time.Second, time.Millisecond)
+	assert.True(t, errors.Is(do(context.Background(), "high"), ErrShed))
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, <-errs)
+	}
+	assert.NoError(t, do(context.Background(), "background"))
+}
+
+func TestLoadSheddingConfig(t *testing.T) {
+	// the header is opt-in
+	p := New(WithLoadShedding(2))
+	req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1", nil)
+	req.Header.Set("X-Priority", "critical")
+	assert.Equal(t, PriorityNormal, p.priorityOf(req))
+
+	// the learned capacity needs a concurrency limiter
+	assert.Panics(t, func() { New(WithLoadShedding(0)) })
+	assert.NotPanics(t, func() { New(WithLoadShedding(0), WithConcurrencyLimiter(limiter.New(limiter.NewAIMD()))) })
+}
+
+func TestLoadSheddingBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	p := New(
+		WithInitialNodes([]selector.Node{selector.NewNode("http", ts.URL[7:], nil)}),
+		WithLoadShedding(2),
+		WithPriorityHeader("X-Priority"),
+	)
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("X-Priority", "background")
+
+	// the request is in flight until its body is closed
+	resp, err := p.Do(req)
+	assert.NoError(t, err)
+	_, err = p.Do(req)
+	assert.True(t, errors.Is(err, ErrShed))
+
+	_ = resp.Body.Close()
+	resp, err = p.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+}
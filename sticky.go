@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/omalloc/proxy/metrics"
+	"github.com/omalloc/proxy/selector"
+)
+
+type stickyKey struct{}
+
+// stickySession routes the requests of a session to the node which served it first.
+// The session carries a signed node id keyed by the secret rather than the raw address,
+// so that the address can't be recovered from it.
+type stickySession struct {
+	secret []byte
+
+	mu  sync.RWMutex
+	ids map[string]string // addr -> id
+}
+
+func newStickySession(secret []byte) *stickySession {
+	return &stickySession{secret: secret, ids: make(map[string]string)}
+}
+
+// id returns the node id of addr.
+func (s *stickySession) id(addr string) string {
+	s.mu.RLock()
+	id, ok := s.ids[addr]
+	s.mu.RUnlock()
+	if ok {
+		return id
+	}
+	id = base64.RawURLEncoding.EncodeToString(s.hmac("node", addr)[:9])
+	s.mu.Lock()
+	s.ids[addr] = id
+	s.mu.Unlock()
+	return id
+}
+
+// apply drops the ids of the removed nodes.
+func (s *stickySession) apply(nodes []selector.Node) {
+	addrs := make(map[string]struct{}, len(nodes))
+	for _, n := range nodes {
+		addrs[n.Address()] = struct{}{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for addr := range s.ids {
+		if _, ok := addrs[addr]; !ok {
+			delete(s.ids, addr)
+		}
+	}
+}
+
+// hmac returns the HMAC of msg in the domain, so that a node id is no valid signature.
+func (s *stickySession) hmac(domain, msg string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(domain))
+	h.Write([]byte{0})
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
+
+func (s *stickySession) mac(id string) []byte {
+	return s.hmac("session", id)[:16]
+}
+
+// sign returns the session value of the node id.
+func (s *stickySession) sign(id string) string {
+	return id + "." + base64.RawURLEncoding.EncodeToString(s.mac(id))
+}
+
+// verify returns the node id of a session value, false if it is forged.
+func (s *stickySession) verify(value string) (string, bool) {
+	id, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.mac(id)) {
+		return "", false
+	}
+	return id, true
+}
+
+// filter keeps the node of id only if it is available and healthy. Otherwise the nodes
+// are kept for the balancer, without the unhealthy node of id unless it is the last one.
+func (s *stickySession) filter(id string) selector.NodeFilter {
+	return func(_ context.Context, nodes []selector.Node) []selector.Node {
+		for i, n := range nodes {
+			if s.id(n.Address()) != id {
+				continue
+			}
+			// e.g. the ewma success ratio collapsed or the reported utilization is too high
+			if h, ok := n.(metrics.HealthReporter); ok && !h.Healthy() {
+				if len(nodes) == 1 {
+					return nodes
+				}
+				return append(slices.Clip(nodes[:i]), nodes[i+1:]...)
+			}
+			return []selector.Node{n}
+		}
+		return nodes
+	}
+}
+
+// stick routes req to the node of its session if any.
+func (r *ReverseProxy) stick(req *http.Request) (*http.Request, string) {
+	if r.sticky == nil {
+		return req, ""
+	}
+	id, ok := r.session(req)
+	if !ok {
+		return req, ""
+	}
+	return req.WithContext(context.WithValue(req.Context(), stickyKey{}, id)), id
+}
+
+// reissue sets the session to resp if the request isn't served by the node of its session.
+func (r *ReverseProxy) reissue(resp *http.Response, addr, id string) {
+	if r.sticky == nil || resp == nil || addr == "" || r.sticky.id(addr) == id {
+		return
+	}
+	value := r.sticky.sign(r.sticky.id(addr))
+	if r.stickyHeader != "" {
+		resp.Header.Set(r.stickyHeader, value)
+	}
+	c := &http.Cookie{
+		Name:     r.stickyCookie,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	resp.Header.Add("Set-Cookie", c.String())
+}
+
+// session returns the node id of the session of req from the header, then the cookie.
+func (r *ReverseProxy) session(req *http.Request) (string, bool) {
+	if r.stickyHeader != "" {
+		if v := req.Header.Get(r.stickyHeader); v != "" {
+			return r.sticky.verify(v)
+		}
+	}
+	if c, err := req.Cookie(r.stickyCookie); err == nil {
+		return r.sticky.verify(c.Value)
+	}
+	return "", false
+}
+
+// stickyFilter returns the node filter of the session in ctx, nil if none.
+func (r *ReverseProxy) stickyFilter(ctx context.Context) selector.NodeFilter {
+	if id, ok := ctx.Value(stickyKey{}).(string); ok && r.sticky != nil {
+		return r.sticky.filter(id)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/node/ewma"
+	"github.com/omalloc/proxy/selector/random"
+)
+
+func TestStickySession(t *testing.T) {
+	s := newStickySession([]byte("secret"))
+	value := s.sign(s.id("127.0.0.1:8080"))
+	assert.NotContains(t, value, "127.0.0.1")
+
+	id, ok := s.verify(value)
+	assert.True(t, ok)
+	assert.Equal(t, s.id("127.0.0.1:8080"), id)
+
+	_, ok = s.verify(s.id("127.0.0.1:8080") + ".forged")
+	assert.False(t, ok)
+	_, ok = newStickySession([]byte("other")).verify(value)
+	assert.False(t, ok)
+	_, ok = s.verify("garbage")
+	assert.False(t, ok)
+
+	// the id is keyed by the secret
+	assert.NotEqual(t, s.id("127.0.0.1:8080"), newStickySession([]byte("other")).id("127.0.0.1:8080"))
+
+	// the ids of the removed nodes are dropped
+	s.apply([]selector.Node{selector.NewNode("http", "127.0.0.1:8081", nil)})
+	assert.Empty(t, s.ids)
+}
+
+func TestReverseProxy_Sticky(t *testing.T) {
+	var hits [3]int
+	var servers []selector.Node
+	for i := range hits {
+		i := i
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i]++
+		}))
+		defer ts.Close()
+		servers = append(servers, selector.NewNode("http", ts.URL[7:], nil))
+	}
+
+	p := New(
+		WithInitialNodes(servers),
+		WithStickySessions([]byte("secret")),
+		WithStickyHeader("X-Sticky"),
+	)
+	do := func(set func(req *http.Request)) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+servers[0].Address(), nil)
+		if set != nil {
+			set(req)
+		}
+		resp, err := p.Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	// the first response issues the session
+	resp := do(nil)
+	cookies := resp.Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, "proxy_sticky", cookies[0].Name)
+		assert.Equal(t, cookies[0].Value, resp.Header.Get("X-Sticky"))
+	}
+	cookie := cookies[0]
+
+	// later requests stick to the node and the session isn't reissued
+	for i := 0; i < 10; i++ {
+		resp = do(func(req *http.Request) { req.AddCookie(cookie) })
+		assert.Empty(t, resp.Cookies())
+	}
+	assert.Contains(t, hits[:], 11)
+
+	// the header is honoured as the cookie
+	for i := 0; i < 5; i++ {
+		do(func(req *http.Request) { req.Header.Set("X-Sticky", cookie.Value) })
+	}
+	assert.Contains(t, hits[:], 16)
+
+	// a forged session falls back to the balancer and is reissued
+	resp = do(func(req *http.Request) {
+		req.AddCookie(&http.Cookie{Name: "proxy_sticky", Value: strings.Replace(cookie.Value, ".", ".x", 1)})
+	})
+	assert.Len(t, resp.Cookies(), 1)
+}
+
+func TestReverseProxy_StickyRemoved(t *testing.T) {
+	var hits [2]int
+	var servers []selector.Node
+	for i := range hits {
+		i := i
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i]++
+		}))
+		defer ts.Close()
+		servers = append(servers, selector.NewNode("http", ts.URL[7:], nil))
+	}
+
+	p := New(WithInitialNodes(servers), WithStickySessions([]byte("secret")))
+	cookie := &http.Cookie{Name: "proxy_sticky", Value: p.sticky.sign(p.sticky.id(servers[0].Address()))}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+servers[0].Address(), nil)
+	req.AddCookie(cookie)
+	resp, err := p.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Empty(t, resp.Cookies())
+	assert.Equal(t, []int{1, 0}, hits[:])
+
+	// the node left the pool, the session moves to another node
+	p.Apply(servers[1:])
+	req, _ = http.NewRequest(http.MethodGet, "http://"+servers[1].Address(), nil)
+	req.AddCookie(cookie)
+	resp, err = p.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, []int{1, 1}, hits[:])
+	if cookies := resp.Cookies(); assert.Len(t, cookies, 1) {
+		id, ok := p.sticky.verify(cookies[0].Value)
+		assert.True(t, ok)
+		assert.Equal(t, p.sticky.id(servers[1].Address()), id)
+	}
+}
+
+func TestReverseProxy_StickyUnhealthy(t *testing.T) {
+	var hits [2]int64
+	var servers []selector.Node
+	for i := range hits {
+		i := i
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i]++
+			if i == 0 {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+		defer ts.Close()
+		servers = append(servers, selector.NewNode("http", ts.URL[7:], nil))
+	}
+
+	p := New(
+		WithSelector(random.New(random.WithNodeBuilder(
+			ewma.NewBuilder(ewma.WithTau(time.Millisecond), ewma.WithClassifier(ewma.HTTPClassifier)),
+		))),
+		WithInitialNodes(servers),
+		WithStickySessions([]byte("secret")),
+	)
+	cookie := &http.Cookie{Name: "proxy_sticky", Value: p.sticky.sign(p.sticky.id(servers[0].Address()))}
+	do := func() *http.Response {
+		time.Sleep(2 * time.Millisecond)
+		req, _ := http.NewRequest(http.MethodGet, "http://"+servers[0].Address(), nil)
+		req.AddCookie(cookie)
+		resp, err := p.Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	// the first failure collapses the success ratio of the node
+	do()
+	assert.Equal(t, int64(1), hits[0])
+	resp := do()
+	assert.Equal(t, int64(1), hits[1])
+	if cookies := resp.Cookies(); assert.Len(t, cookies, 1) {
+		id, _ := p.sticky.verify(cookies[0].Value)
+		assert.Equal(t, p.sticky.id(servers[1].Address()), id)
+	}
+}
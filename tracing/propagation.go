@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// W3C trace context headers, see https://www.w3.org/TR/trace-context/
+const (
+	TraceparentHeader = "Traceparent"
+	TracestateHeader  = "Tracestate"
+)
+
+const (
+	supportedVersion = "00"
+	flagSampled      = 0x01
+)
+
+// Inject writes the span context in ctx into the traceparent and tracestate headers,
+// nothing is written if ctx carries no valid span context.
+func Inject(ctx context.Context, header http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	header.Set(TraceparentHeader, supportedVersion+"-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+flags)
+	if sc.TraceState != "" {
+		header.Set(TracestateHeader, sc.TraceState)
+	} else {
+		header.Del(TracestateHeader)
+	}
+}
+
+// Extract returns a new context carrying the remote span context in header,
+// ctx is returned unchanged if the traceparent header is missing or malformed.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := Parse(header.Get(TraceparentHeader))
+	if !ok {
+		return ctx
+	}
+	sc.TraceState = header.Get(TracestateHeader)
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Parse parses a traceparent header value.
+func Parse(traceparent string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+	// version 00 has exactly four fields, future versions may append more.
+	if parts[0] == supportedVersion && len(parts) != 4 {
+		return sc, false
+	}
+	var flags [1]byte
+	if !decode(sc.TraceID[:], parts[1]) || !decode(sc.SpanID[:], parts[2]) || !decode(flags[:], parts[3]) {
+		return SpanContext{}, false
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&flagSampled != 0
+	return sc, true
+}
+
+// decode decodes lower-case hex s into dst, s must fill dst exactly.
+func decode(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+var _ Tracer = (*Recorder)(nil)
+
+// Recorder is an in-memory tracer which keeps every ended span, useful in tests.
+type Recorder struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+// NewRecorder create an in-memory tracer.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Start a recorded span, a new trace is started if ctx has no parent.
+func (r *Recorder) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	parent := SpanContextFromContext(ctx)
+	span := &RecordedSpan{
+		recorder:   r,
+		Name:       name,
+		Parent:     parent,
+		Attributes: make(map[string]any, len(attrs)),
+		StartTime:  time.Now(),
+	}
+	span.sc = SpanContext{
+		TraceID:    parent.TraceID,
+		Sampled:    true,
+		TraceState: parent.TraceState,
+	}
+	if !parent.IsValid() {
+		_, _ = rand.Read(span.sc.TraceID[:])
+	}
+	_, _ = rand.Read(span.sc.SpanID[:])
+	span.SetAttributes(attrs...)
+	return ContextWithSpan(ctx, span), span
+}
+
+// Spans returns the ended spans in end order.
+func (r *Recorder) Spans() []*RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	spans := make([]*RecordedSpan, len(r.spans))
+	copy(spans, r.spans)
+	return spans
+}
+
+// Reset drops all ended spans.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = nil
+}
+
+func (r *Recorder) end(span *RecordedSpan) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = append(r.spans, span)
+}
+
+// RecordedSpan is a span kept by Recorder, read it after the span ended.
+type RecordedSpan struct {
+	recorder *Recorder
+	mu       sync.Mutex
+	sc       SpanContext
+	ended    bool
+
+	Name       string
+	Parent     SpanContext
+	Attributes map[string]any
+	Err        error
+	StartTime  time.Time
+	EndTime    time.Time
+}
+
+// SpanContext returns the propagated identity of the span.
+func (s *RecordedSpan) SpanContext() SpanContext {
+	return s.sc
+}
+
+// SetAttributes annotate the span.
+func (s *RecordedSpan) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, attr := range attrs {
+		s.Attributes[attr.Key] = attr.Value
+	}
+}
+
+// RecordError marks the span as failed.
+func (s *RecordedSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Err = err
+}
+
+// End finish the span.
+func (s *RecordedSpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+
+	s.recorder.end(s)
+}
@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+)
+
+// attribute keys annotated by the proxy.
+const (
+	AttrNodeAddress = "proxy.node.address"
+	AttrBalancer    = "proxy.balancer"
+	AttrRetry       = "proxy.retry"
+	AttrStatusCode  = "http.status_code"
+	AttrErrorClass  = "proxy.error.class"
+)
+
+// Tracer starts spans.
+type Tracer interface {
+	// Start a span as the child of the span in ctx, the returned context carries the new span.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a single operation within a trace.
+type Span interface {
+	// SpanContext returns the propagated identity of the span.
+	SpanContext() SpanContext
+	// SetAttributes annotate the span.
+	SetAttributes(attrs ...Attribute)
+	// RecordError marks the span as failed.
+	RecordError(err error)
+	// End finish the span, calls after the first one are ignored.
+	End()
+}
+
+// Attribute is a key-value pair annotated on a span.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String create a string attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int create an integer attribute.
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// TraceID is a W3C trace id.
+type TraceID [16]byte
+
+// IsValid reports whether the trace id is not all zero.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID is a W3C span (parent) id.
+type SpanID [8]byte
+
+// IsValid reports whether the span id is not all zero.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext is the part of a span propagated across process boundaries.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid reports whether the span context can be propagated.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a new context carrying span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span in ctx if it exists.
+func SpanFromContext(ctx context.Context) (span Span, ok bool) {
+	span, ok = ctx.Value(spanKey{}).(Span)
+	return
+}
+
+type remoteKey struct{}
+
+// ContextWithRemoteSpanContext returns a new context carrying a span context extracted from a remote parent.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span in ctx,
+// falls back to the remote parent.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span, ok := SpanFromContext(ctx); ok {
+		return span.SpanContext()
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+var _ Tracer = NoopTracer{}
+
+// NoopTracer is a tracer which records and propagates nothing, it's the default tracer.
+type NoopTracer struct{}
+
+// Start returns ctx and a no-op span.
+func (NoopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SpanContext() SpanContext   { return SpanContext{} }
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
@@ -0,0 +1,72 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/proxy/tracing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		ok          bool
+		sampled     bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"future version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"short span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01", false, false},
+		{"empty", "", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := tracing.Parse(tt.traceparent)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.sampled, sc.Sampled)
+		})
+	}
+}
+
+func TestRecorderPropagation(t *testing.T) {
+	incoming := http.Header{}
+	incoming.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	incoming.Set(tracing.TracestateHeader, "congo=t61rcWkgMzE")
+
+	r := tracing.NewRecorder()
+	ctx, span := r.Start(tracing.Extract(context.Background(), incoming), "child", tracing.Int(tracing.AttrRetry, 0))
+
+	outgoing := http.Header{}
+	tracing.Inject(ctx, outgoing)
+	span.End()
+	span.End()
+
+	sc, ok := tracing.Parse(outgoing.Get(tracing.TraceparentHeader))
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, span.SpanContext().SpanID, sc.SpanID)
+	assert.Equal(t, "congo=t61rcWkgMzE", outgoing.Get(tracing.TracestateHeader))
+
+	spans := r.Spans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent.SpanID.String())
+	assert.Equal(t, 0, spans[0].Attributes[tracing.AttrRetry])
+}
+
+func TestNoopTracer(t *testing.T) {
+	ctx, span := tracing.NoopTracer{}.Start(context.Background(), "noop")
+	header := http.Header{}
+	tracing.Inject(ctx, header)
+	span.End()
+
+	assert.False(t, span.SpanContext().IsValid())
+	assert.Empty(t, header.Get(tracing.TraceparentHeader))
+}